@@ -0,0 +1,844 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+	Builds corpora out of demos. A Builder owns its corpora, their vocabularies and its output folder, so several can live in one
+	process, and ProcessDemo can be called from multiple goroutines at once: every demo is parsed into its own scratch corpora and
+	only merging those into the Builder's corpora is serialized.
+
+	If the output folder already has a manifest (and Config.Rebuild isn't set) the existing corpora are appended to instead of
+	being truncated, keeping their vocabularies.
+
+	With Config.ByPatch every patch gets corpora of its own, with their own vocabularies, in a folder named after it (see
+	PatchRoot). The manifest stays in the output folder and says which folder each demo went into.
+*/
+type Builder struct {
+	Config Config
+
+	mutex    sync.Mutex
+	corpora  map[string]*Corpora // by patch, only "" unless Config.ByPatch
+	manifest *Manifest
+	resuming bool            // none of the corpora on disk have been reopened yet
+	existing map[string]bool // patches with corpora on disk to reopen before anything is merged into them
+	scratch  string          // folder for the per-demo corpora
+	selector PlayerSelector
+	features FeatureSchema
+	memory   *MemoryBudget // shared by every single pass, however many run at once
+	closed   bool
+}
+
+/* Creates a Builder writing into config.OutputDir. Zero TopK and SinglePassMemory get their defaults. */
+func NewBuilder(config Config) (*Builder, error) {
+	if config.TopK == 0 {
+		config.TopK = DEFAULT_TOP_K
+	} else if config.TopK < 0 {
+		return nil, errors.New("TopK can't be negative")
+	}
+
+	if config.SinglePassMemory == 0 {
+		config.SinglePassMemory = SINGLE_PASS_MEMORY
+	}
+
+	selector, err := config.Selector()
+
+	if err != nil {
+		return nil, err
+	}
+
+	features, err := NewFeatureSchema(config.Features)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.OutputDir, 493); err != nil {
+		return nil, err
+	}
+
+	builder := &Builder{Config: config, corpora: make(map[string]*Corpora), manifest: &Manifest{}, existing: make(map[string]bool), selector: selector, features: features, memory: NewMemoryBudget(config.SinglePassMemory)}
+
+	if !config.Rebuild {
+		manifest, err := LoadManifest(config.OutputDir)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", MANIFEST_FILE, err)
+		}
+
+		if manifest != nil {
+			for _, entry := range manifest.Demos {
+				if entry.Version != BUILDER_VERSION {
+					return nil, fmt.Errorf("%s was built by builder version %d (this is version %d), rebuild it from scratch", config.OutputDir, entry.Version, BUILDER_VERSION)
+				}
+
+				if entry.Skipped == "" && entry.Patch == "" && config.ByPatch {
+					return nil, fmt.Errorf("%s isn't split by patch, rebuild it from scratch to use -by-patch", config.OutputDir)
+				} else if entry.Skipped == "" && entry.Patch != "" && !config.ByPatch {
+					return nil, fmt.Errorf("%s is split by patch, build it with -by-patch", config.OutputDir)
+				}
+
+				if entry.Skipped == "" {
+					builder.existing[entry.Patch] = true
+				}
+			}
+
+			builder.manifest = manifest
+			builder.resuming = true
+			builder.existing[""] = !config.ByPatch
+		}
+	}
+
+	scratch, err := ioutil.TempDir("", "corpus_builder")
+
+	if err != nil {
+		return nil, err
+	}
+
+	builder.scratch = scratch
+	return builder, nil
+}
+
+/*
+	The corpora of a patch ("" when they aren't split by patch), reopening the ones already on disk the first time they're
+	needed. Called with the mutex held.
+*/
+func (builder *Builder) open(patch string) (*Corpora, error) {
+	if corpora, ok := builder.corpora[patch]; ok {
+		return corpora, nil
+	}
+
+	corpora := NewCorpora(PatchRoot(builder.Config.OutputDir, patch))
+	corpora.Patch = patch
+	corpora.Features = builder.features
+
+	if builder.existing[patch] {
+		if err := corpora.Resume(); err != nil {
+			return nil, err
+		}
+
+		if !corpora.Features.Equal(builder.features) {
+			return nil, fmt.Errorf("%s was built with %s, not %s; rebuild it from scratch to change them", corpora.Root, corpora.Features, builder.features)
+		}
+	}
+
+	builder.corpora[patch] = corpora
+	builder.resuming = false
+	return corpora, nil
+}
+
+/* Where the Lua vocabulary of a patch's corpora goes: Config.VocabPath, or a file of the same name in the patch's folder. */
+func (builder *Builder) vocabPath(patch string) string {
+	if patch == "" || builder.Config.VocabPath == "" {
+		return builder.Config.VocabPath
+	}
+
+	return filepath.Join(PatchRoot(builder.Config.OutputDir, patch), filepath.Base(builder.Config.VocabPath))
+}
+
+/*
+	Parses a demo into fresh scratch corpora and returns their folder. open is called once per pass, and has to return the demo
+	from the start every time.
+*/
+func (builder *Builder) parse(ctx context.Context, open func() (io.ReadCloser, error), singlePass bool) (root string, err error) {
+	if root, err = ioutil.TempDir(builder.scratch, "demo"); err != nil {
+		return "", err
+	}
+
+	corpora := NewCorpora(root)
+
+	defer func() {
+		// Manta panics on some malformed demos; treat that like any other broken demo
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("parser panicked: %v", recovered)
+		}
+
+		if closeErr := corpora.CloseCorpora(""); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			os.RemoveAll(root)
+			root = ""
+		}
+	}()
+
+	demo, err := open()
+
+	if err != nil {
+		return root, err
+	}
+
+	if singlePass {
+		defer demo.Close()
+
+		players, err := ioutil.TempDir(builder.scratch, "players")
+
+		if err != nil {
+			return root, err
+		}
+
+		defer os.RemoveAll(players)
+
+		info, err := SinglePass(ctx, corpora, demo, builder.selector, players, builder.memory, builder.options())
+
+		if err != nil {
+			return root, err
+		}
+
+		logMatch(info)
+
+		if err := builder.filter(info); err != nil { // only known once the examples are made already
+			return root, err
+		}
+
+		corpora.Patch = builder.patch(info)
+		corpora.Features = builder.features
+
+		return root, NewMatchMetadata(info, builder.Config.Patches).Write(filepath.Join(root, MATCH_FILE))
+	}
+
+	info, err := FirstPass(ctx, corpora, demo, builder.selector) // retrieve selected players
+	demo.Close()
+
+	if err != nil {
+		return root, err
+	}
+
+	logMatch(info)
+
+	if err := builder.filter(info); err != nil {
+		return root, err
+	}
+
+	corpora.Patch = builder.patch(info)
+	corpora.Features = builder.features
+
+	if err := NewMatchMetadata(info, builder.Config.Patches).Write(filepath.Join(root, MATCH_FILE)); err != nil {
+		return root, err
+	}
+
+	if demo, err = open(); err != nil { // go back to beginning of demo
+		return root, err
+	}
+
+	defer demo.Close()
+
+	return root, SecondPass(ctx, corpora, demo, info, builder.options()) // make examples
+}
+
+/* What goes into the examples, according to the config. */
+func (builder *Builder) options() ExampleOptions {
+	return ExampleOptions{DropPaused: builder.Config.DropPaused, Sequences: builder.Config.Sequences, Features: builder.features}
+}
+
+/* Checks a parsed match against Config.Filter. With Config.ByPatch, matches of unknown patches are filtered out too. */
+func (builder *Builder) filter(info *MatchInfo) error {
+	patch := builder.Config.Patches.Lookup(info.Build)
+
+	if builder.Config.ByPatch && patch == "" {
+		return filtered(fmt.Sprintf("patch of build %d is unknown, so it has no patch folder", info.Build))
+	}
+
+	return builder.Config.Filter.Check(info.GameMode, info.LobbyType, info.Build, patch)
+}
+
+/* The patch folder a match's examples go into, "" unless Config.ByPatch. */
+func (builder *Builder) patch(info *MatchInfo) string {
+	if !builder.Config.ByPatch {
+		return ""
+	}
+
+	return builder.Config.Patches.Lookup(info.Build)
+}
+
+func logMatch(info *MatchInfo) {
+	Debugf("Horn at tick %d, winning team data entity %d\n", info.StartTime, info.TeamIndex)
+
+	for id, player := range info.Top {
+		log.Println(id, player.Name, player.Kills, player.Deaths, player.Assists)
+	}
+}
+
+/* Parses a demo and adds its examples to the builder's corpora. Demos that are left out on purpose return a *SkipError. */
+func (builder *Builder) ProcessDemo(ctx context.Context, demo io.ReadSeeker) error {
+	fingerprint := PeekDemo(demo)
+
+	hasher := newDemoHasher(func() (io.ReadCloser, error) {
+		_, err := demo.Seek(0, io.SeekStart)
+		return ioutil.NopCloser(demo), err
+	})
+
+	root, err := builder.parse(ctx, hasher.Open, builder.Config.SinglePass)
+	fingerprint.Hash = hasher.Sum()
+
+	if skip, ok := err.(*SkipError); ok {
+		return FirstError(builder.skip("", fingerprint, skip), skip)
+	} else if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(root)
+
+	_, err = builder.merge(root, "", fingerprint)
+
+	if skip, ok := err.(*SkipError); ok {
+		return FirstError(builder.skip("", fingerprint, skip), skip)
+	}
+
+	return err
+}
+
+/* Like ProcessDemo, for demos that can only be read once. Always uses the single pass. */
+func (builder *Builder) ProcessStream(ctx context.Context, demo io.Reader) error {
+	pipe, tee := io.Pipe()
+	fingerprints := make(chan Fingerprint, 1)
+
+	go func() { // fingerprints the demo as the parser reads it
+		fingerprint, _ := FingerprintDemo(pipe)
+		io.Copy(ioutil.Discard, pipe)
+		fingerprints <- fingerprint
+	}()
+
+	stream := io.TeeReader(demo, tee)
+	read := false
+
+	once := func() (io.ReadCloser, error) {
+		if read {
+			return nil, errors.New("stream can only be read once")
+		}
+
+		read = true
+		return ioutil.NopCloser(stream), nil
+	}
+
+	root, err := builder.parse(ctx, once, true)
+
+	if _, skipped := err.(*SkipError); err == nil || skipped {
+		if _, copyErr := io.Copy(ioutil.Discard, stream); copyErr != nil { // the parser stops at the end of the game, fingerprint the rest too
+			err = copyErr
+		}
+	}
+
+	tee.Close()
+	fingerprint := <-fingerprints
+
+	if root != "" {
+		defer os.RemoveAll(root)
+	}
+
+	if err == nil {
+		_, err = builder.merge(root, "", fingerprint)
+	}
+
+	if skip, ok := err.(*SkipError); ok {
+		return FirstError(builder.skip("", fingerprint, skip), skip)
+	}
+
+	return err
+}
+
+/*
+	Records a demo that was left out on purpose, so it's skipped as unchanged next time instead of being parsed again. Filtered
+	demos aren't recorded, so they're looked at again if the next run filters differently.
+*/
+func (builder *Builder) skip(demo string, fingerprint Fingerprint, reason *SkipError) error {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+
+	if builder.closed {
+		return errors.New("builder is closed")
+	}
+
+	if reason.Filtered {
+		return nil
+	}
+
+	builder.manifest.Demos = append(builder.manifest.Demos, &ManifestEntry{
+		Demo:     demo,
+		Hash:     fingerprint.Hash,
+		MatchID:  fingerprint.MatchID,
+		Version:  BUILDER_VERSION,
+		Examples: map[string][]ExampleCounts{},
+		Skipped:  reason.Reason,
+	})
+
+	return nil
+}
+
+/* Adds the corpora in an existing corpora folder (such as another build's output) to the builder's corpora. */
+func (builder *Builder) Merge(root string) error {
+	entry, err := builder.merge(root, root, Fingerprint{})
+
+	if err != nil {
+		return err
+	}
+
+	return CopyMatches(root, PatchRoot(builder.Config.OutputDir, entry.Patch))
+}
+
+/*
+	Merges a corpora folder and records it in the manifest under demo. The match metadata a parse left in the folder is
+	completed with what the fingerprint knows and written to the matches folder next to the corpora it went into. With
+	Config.ByPatch the folder goes into the corpora of its patch.
+
+	Returns a *SkipError instead if the demo is byte for byte the same as one already in the corpora, or its metadata shows the
+	match is, which plan can't always tell beforehand: new demos are only hashed while they're parsed, and the file info of
+	compressed ones isn't read up front.
+*/
+func (builder *Builder) merge(root string, demo string, fingerprint Fingerprint) (*ManifestEntry, error) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+
+	if builder.closed {
+		return nil, errors.New("builder is closed")
+	}
+
+	patch := ""
+
+	if builder.Config.ByPatch {
+		vocab, err := LoadVocabulary(root)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if patch = vocab.Patch; patch == "" {
+			return nil, fmt.Errorf("%s isn't from a known patch", root)
+		}
+	}
+
+	if fingerprint.Hash != "" {
+		for _, other := range builder.manifest.Demos {
+			if other.Hash == fingerprint.Hash {
+				return nil, &SkipError{Reason: "same demo as " + other.Demo}
+			}
+		}
+	}
+
+	metadata, err := LoadMatchMetadata(filepath.Join(root, MATCH_FILE))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata != nil && metadata.MatchID != 0 {
+		for _, other := range builder.manifest.Demos {
+			if other.MatchID == metadata.MatchID {
+				return nil, &SkipError{Reason: fmt.Sprintf("match %d is already in %s", metadata.MatchID, other.Demo)}
+			}
+		}
+	}
+
+	corpora, err := builder.open(patch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := corpora.Merge(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Demo = demo
+	entry.Hash = fingerprint.Hash
+	entry.MatchID = fingerprint.MatchID
+	entry.Version = BUILDER_VERSION
+	entry.Patch = patch
+
+	if metadata != nil {
+		metadata.Demo = demo
+		metadata.Hash = fingerprint.Hash
+		metadata.AddFileInfo(fingerprint.FileInfo)
+
+		if entry.MatchID == 0 {
+			entry.MatchID = metadata.MatchID
+		}
+
+		if err := metadata.Write(MatchPath(corpora.Root, entry.MatchID, entry.Hash)); err != nil {
+			return nil, err
+		}
+	}
+
+	builder.manifest.Demos = append(builder.manifest.Demos, entry)
+	return entry, nil
+}
+
+/* A demo that's been turned into its own corpora folder and is waiting to be merged. */
+type demoResult struct {
+	index       int
+	root        string
+	fingerprint Fingerprint
+	err         error
+}
+
+/* An entry in the --keep-going error report. */
+type DemoError struct {
+	Demo  string `json:"demo"`
+	Error string `json:"error"`
+}
+
+const ERROR_REPORT_FILE = "errors.json"
+
+/* Returned for demos that are left out on purpose (such as matches without a winner) rather than because they're broken. */
+type SkipError struct {
+	Reason   string
+	Filtered bool // left out by Config.Filter, which can be different next run
+}
+
+func (err *SkipError) Error() string {
+	return err.Reason
+}
+
+/* What a BuildDemos run did, written to summary.json in the output folder. */
+type RunSummary struct {
+	Demos   int         `json:"demos"`   // found
+	Parsed  int         `json:"parsed"`  // and merged
+	Skipped []DemoError `json:"skipped"` // left out on purpose, and why
+	Failed  []DemoError `json:"failed"`
+}
+
+const SUMMARY_FILE = "summary.json"
+
+func (summary *RunSummary) Write(root string) error {
+	if output, err := json.MarshalIndent(summary, "", "\t"); err == nil {
+		return ioutil.WriteFile(filepath.Join(root, SUMMARY_FILE), output, 0644)
+	} else {
+		return err
+	}
+}
+
+/*
+	Fingerprints demos on jobs workers. Only demos that are in the manifest under their name are hashed here, to tell whether
+	they changed; the rest are hashed while they're parsed (see demoHasher), so they only get their file info. Demos that can't
+	be read get an empty fingerprint so parsing them reports the error.
+*/
+func fingerprintDemos(demos []DemoSource, known map[string]*ManifestEntry, jobs int) []Fingerprint {
+	fingerprints := make([]Fingerprint, len(demos))
+	queue := make(chan int)
+
+	var workers sync.WaitGroup
+
+	for w := 0; w < jobs; w++ {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+
+			for i := range queue {
+				fingerprint := demos[i].Peek
+
+				if _, ok := known[demos[i].Name]; ok {
+					fingerprint = demos[i].Fingerprint
+				}
+
+				if fingerprint, err := fingerprint(); err == nil {
+					fingerprints[i] = fingerprint
+				}
+			}
+		}()
+	}
+
+	for i := range demos {
+		queue <- i
+	}
+
+	close(queue)
+	workers.Wait()
+
+	return fingerprints
+}
+
+/*
+	Works out which demos still have to be parsed: ones that aren't in the manifest yet or whose contents changed since. The
+	examples of changed demos are cut out of the corpora first so they don't end up in there twice.
+
+	Demos that are byte for byte the same as, or record the same match as, a demo that's already in the corpora or comes earlier
+	in demos are skipped, so no game gets counted twice. So are demos whose file info already shows Config.Filter doesn't want
+	their game mode. Duplicates that the fingerprints can't show yet are skipped when they're merged instead.
+*/
+func (builder *Builder) plan(demos []DemoSource, fingerprints []Fingerprint) ([]int, []DemoError, error) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+
+	if builder.resuming { // existing corpora haven't been reopened yet, so changed demos can still be cut out
+		seen := builder.manifest.ByDemo()
+		changed := make(map[string]bool)
+
+		for i, demo := range demos {
+			if entry, ok := seen[demo.Name]; ok && (fingerprints[i].Hash == "" || entry.Hash != fingerprints[i].Hash) {
+				log.Printf("%s changed since the last build, replacing its examples\n", demo.Name)
+				changed[demo.Name] = true
+			}
+		}
+
+		if len(changed) > 0 {
+			err := builder.manifest.Drop(builder.Config.OutputDir, func(entry *ManifestEntry) bool {
+				if changed[entry.Demo] {
+					os.Remove(MatchPath(PatchRoot(builder.Config.OutputDir, entry.Patch), entry.MatchID, entry.Hash))
+				}
+
+				return changed[entry.Demo]
+			})
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	hashes := make(map[string]string)  // hash -> demo
+	matches := make(map[uint64]string) // match ID -> demo
+
+	for _, entry := range builder.manifest.Demos {
+		if _, ok := hashes[entry.Hash]; !ok && entry.Hash != "" { // later entries with the same hash were skipped as copies of this one
+			hashes[entry.Hash] = entry.Demo
+		}
+
+		if entry.MatchID != 0 {
+			matches[entry.MatchID] = entry.Demo
+		}
+	}
+
+	var todo []int
+	var excluded []DemoError
+
+	for i, demo := range demos {
+		fingerprint := fingerprints[i]
+
+		if other, ok := hashes[fingerprint.Hash]; ok && other == demo.Name {
+			Debugf("Skipping %s (unchanged)\n", demo.Name)
+			continue
+		} else if ok {
+			log.Printf("Skipping %s (same demo as %s)\n", demo.Name, other)
+			excluded = append(excluded, DemoError{demo.Name, "same demo as " + other})
+			continue
+		} else if other, ok := matches[fingerprint.MatchID]; ok {
+			log.Printf("Skipping %s (match %d is already in %s)\n", demo.Name, fingerprint.MatchID, other)
+			excluded = append(excluded, DemoError{demo.Name, fmt.Sprintf("match %d is already in %s", fingerprint.MatchID, other)})
+			continue
+		}
+
+		if mode := fingerprint.FileInfo.GetGameInfo().GetDota().GetGameMode(); mode != 0 {
+			if err := builder.Config.Filter.CheckMode(mode); err != nil {
+				log.Printf("Skipping %s (%s)\n", demo.Name, err)
+				excluded = append(excluded, DemoError{demo.Name, err.Error()})
+				continue
+			}
+		}
+
+		if fingerprint.Hash != "" {
+			hashes[fingerprint.Hash] = demo.Name
+		}
+
+		if fingerprint.MatchID != 0 {
+			matches[fingerprint.MatchID] = demo.Name
+		}
+
+		todo = append(todo, i)
+	}
+
+	return todo, excluded, nil
+}
+
+/*
+	Parses demo files on a pool of workers. Every demo gets written into its own scratch corpora, which are then merged strictly in
+	the order the demos were given, so the output is the same no matter how many workers there are or which of them finishes first.
+
+	Normally the first broken demo stops the build. With Config.KeepGoing broken demos are left out, listed in errors.json in the
+	output folder, and reported in the returned error once everything else has been merged. Demos left out on purpose (duplicates,
+	matches without a winner, matches Config.Filter excludes) never stop the build; they're listed with everything else in
+	summary.json.
+*/
+func (builder *Builder) BuildDemos(ctx context.Context, paths []string, jobs int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	demos, err := ExpandDemos(paths, builder.scratch)
+
+	if err != nil {
+		return err
+	}
+
+	builder.mutex.Lock()
+	known := builder.manifest.ByDemo()
+	builder.mutex.Unlock()
+
+	fingerprints := fingerprintDemos(demos, known, jobs)
+	todo, excluded, err := builder.plan(demos, fingerprints)
+
+	if err != nil {
+		return err
+	}
+
+	summary := &RunSummary{Demos: len(demos), Skipped: append([]DemoError{}, excluded...), Failed: []DemoError{}}
+
+	log.Printf("%d of %d demos to parse\n", len(todo), len(demos))
+
+	queue := make(chan int)
+	results := make(chan demoResult)
+
+	var workers sync.WaitGroup
+
+	for w := 0; w < jobs; w++ {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+
+			for i := range queue {
+				log.Printf("Demo %d (%s)\n", i+1, demos[i].Name)
+
+				fingerprint := fingerprints[i]
+				open := demos[i].Open
+				var hasher *demoHasher
+
+				if fingerprint.Hash == "" {
+					hasher = newDemoHasher(open)
+					open = hasher.Open
+				}
+
+				root, err := builder.parse(ctx, open, builder.Config.SinglePass)
+
+				if hasher != nil {
+					fingerprint.Hash = hasher.Sum()
+				}
+
+				results <- demoResult{i, root, fingerprint, err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, i := range todo {
+			if ctx.Err() != nil {
+				break
+			}
+
+			queue <- i
+		}
+
+		close(queue)
+		workers.Wait()
+		close(results)
+	}()
+
+	var failures []DemoError
+
+	pending := make(map[int]demoResult)
+	next := 0
+
+	for result := range results {
+		pending[result.index] = result
+
+		// merge every demo we can without skipping ahead of one that's still being parsed
+		for result, ok := pending[todo[next]]; ok; result, ok = pending[todo[next]] {
+			demo := demos[result.index].Name
+			demoErr := result.err
+
+			if skip, ok := demoErr.(*SkipError); ok && err == nil {
+				log.Printf("Skipping demo %d (%s): %s\n", result.index+1, demo, skip)
+				summary.Skipped = append(summary.Skipped, DemoError{demo, skip.Reason})
+				demoErr = builder.skip(demo, result.fingerprint, skip)
+			} else if demoErr == nil && err == nil {
+				if _, demoErr = builder.merge(result.root, demo, result.fingerprint); demoErr == nil {
+					summary.Parsed++
+				} else if skip, ok := demoErr.(*SkipError); ok { // same match as an earlier demo
+					log.Printf("Skipping demo %d (%s): %s\n", result.index+1, demo, skip)
+					summary.Skipped = append(summary.Skipped, DemoError{demo, skip.Reason})
+					demoErr = builder.skip(demo, result.fingerprint, skip)
+				}
+			}
+
+			if demoErr != nil && err == nil {
+				if builder.Config.KeepGoing && result.err != nil { // merge failures mean the corpora themselves are broken, never keep going on those
+					log.Printf("Skipping demo %d (%s): %s\n", result.index+1, demo, demoErr)
+					failures = append(failures, DemoError{demo, demoErr.Error()})
+					summary.Failed = append(summary.Failed, DemoError{demo, demoErr.Error()})
+				} else {
+					err = fmt.Errorf("%s: %s", demo, demoErr)
+					cancel()
+				}
+			}
+
+			if result.root != "" {
+				os.RemoveAll(result.root)
+			}
+
+			delete(pending, result.index)
+
+			if next++; next == len(todo) {
+				break
+			}
+		}
+	}
+
+	if builder.Config.KeepGoing {
+		if reportErr := WriteErrorReport(filepath.Join(builder.Config.OutputDir, ERROR_REPORT_FILE), failures); err == nil {
+			err = reportErr
+		}
+
+		if err == nil && len(failures) > 0 {
+			err = fmt.Errorf("%d of %d demos failed, see %s", len(failures), len(demos), ERROR_REPORT_FILE)
+		}
+	}
+
+	log.Printf("%d demos parsed, %d skipped, %d failed\n", summary.Parsed, len(summary.Skipped), len(summary.Failed))
+
+	return FirstError(err, summary.Write(builder.Config.OutputDir))
+}
+
+/* Writes the --keep-going error report. */
+func WriteErrorReport(path string, failures []DemoError) error {
+	if failures == nil {
+		failures = []DemoError{}
+	}
+
+	if output, err := json.MarshalIndent(failures, "", "\t"); err == nil {
+		return ioutil.WriteFile(path, output, 0644)
+	} else {
+		return err
+	}
+}
+
+/* Closes the corpora, writes the vocabularies and cleans up the scratch folder. */
+func (builder *Builder) Close() error {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+
+	if builder.closed {
+		return errors.New("builder is already closed")
+	}
+
+	builder.closed = true
+
+	if !builder.Config.ByPatch {
+		if _, err := builder.open(""); err != nil { // nothing was merged, but the vocabulary files still get rewritten
+			os.RemoveAll(builder.scratch)
+			return err
+		}
+	}
+
+	var errs []error
+
+	for patch, corpora := range builder.corpora {
+		errs = append(errs, corpora.CloseCorpora(builder.vocabPath(patch)))
+	}
+
+	return FirstError(append(errs,
+		builder.manifest.Write(builder.Config.OutputDir),
+		os.RemoveAll(builder.scratch),
+	)...)
+}
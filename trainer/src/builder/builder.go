@@ -1,19 +1,26 @@
 package builder
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"io"
 	"strings"
 
 	"github.com/dotabuff/manta"
 	"github.com/dotabuff/manta/dota"
 )
 
-/* Represents a player to pay attention to in the first pass. */
+/* Represents a player to pay attention to in the first pass, with their stats at the end of the game. */
 type TopPlayer struct {
-	Kills int32
-	Name  string
+	ID       int32   `json:"player_id"`
+	Team     int32   `json:"team"`
+	Name     string  `json:"name"`
+	SteamID  uint64  `json:"steam_id"`
+	Kills    int32   `json:"kills"`
+	Deaths   int32   `json:"deaths"`
+	Assists  int32   `json:"assists"`
+	NetWorth int32   `json:"net_worth"`
+	GPM      float32 `json:"gpm"`
 }
 
 type Hero struct {
@@ -23,66 +30,130 @@ type Hero struct {
 	LastInventorySave uint32
 }
 
-/* Current corpora. */
-var corpora = Corpora{Corpora: make(map[string][]*Corpus)}
+/* What the first pass learns about a match. */
+type MatchInfo struct {
+	StartTime   uint32               // horn, in ticks
+	EndTime     uint32               // tick the winner was decided on
+	WinningTeam int32                // 2 (Radiant) or 3 (Dire), 0 while the game is still going
+	TeamIndex   int32                // entindex of the winning team's CDOTA_DataRadiant/CDOTA_DataDire
+	Top         map[int32]*TopPlayer // selected players by player ID
+	Teams       map[string]uint64    // team composition
+	Lanes       map[int32]int        // lane each player was assigned by player ID, guessed from the laning phase
+
+	StartClock float32 // gamerules clock at the horn
+	HasClock   bool    // whether the gamerules network their clock, otherwise time is measured in ticks
+
+	MatchID   uint64 // 0 if the replay doesn't say
+	Build     uint32 // server build
+	GameMode  int32
+	LobbyType int32
+}
+
+/* Records the winner the gamerules name, the first time they name Radiant (2) or Dire (3), and the tick they did. */
+func (info *MatchInfo) SetWinner(winner int32, tick uint32) {
+	if info.WinningTeam == 0 && (winner == 2 || winner == 3) {
+		info.WinningTeam = winner
+		info.EndTime = tick
+	}
+}
 
 /*
-	Retrieves the top 3 players on the winning team and also gets the start time of the match (horn) in ticks.
+	Watches a parse for the start time of the match (horn) in ticks, the winner and the players selector picks once the game is
+	over, and which lane every player laned in. Stops the parser once it has all of them.
 */
-func FirstPass(filehandle *os.File) (map[int32]*TopPlayer, uint32, int32) {
-	parser := CreateParser(filehandle)
-
-	var startTime uint32
-	var winningTeam int32
-	var teamIndex int32
-
-	top3 := make(map[int32]*TopPlayer)
-	teamComposition := make(map[string]uint64)
+func WatchMatch(ctx context.Context, parser *manta.Parser, selector PlayerSelector) *MatchInfo {
+	info := &MatchInfo{Top: make(map[int32]*TopPlayer), Teams: make(map[string]uint64), Lanes: make(map[int32]int)}
+	lanes := NewLaneAssigner()
+	teamData := make(map[uint64]int32) // team -> entindex of its CDOTA_DataRadiant/CDOTA_DataDire
+	selected := false
+	hasState, hasWinner := false, false // whether the gamerules network them, otherwise the heuristics below are used
+	gamerules := int32(-1)
 
 	parser.OnEntity(func(ent *manta.Entity, _ manta.EntityOp) error {
+		if ctx.Err() != nil { // cancelled
+			parser.Stop()
+			return nil
+		}
+
 		classname := ent.GetClassName()
+		winningTeam := info.WinningTeam
+
+		switch classname {
+		case "CDOTA_DataRadiant":
+			teamData[2] = ent.GetIndex()
+		case "CDOTA_DataDire":
+			teamData[3] = ent.GetIndex()
+		case GAMERULES:
+			gamerules = ent.GetIndex()
+			info.Build = parser.GameBuild
+			state, ok := ent.GetInt32("m_pGameRules.m_nGameState")
+			hasState = hasState || ok
+
+			if info.StartTime == 0 && (state == GAME_IN_PROGRESS || state == POST_GAME) {
+				info.StartTime = parser.Tick
+				info.StartClock, info.HasClock = GameTime(parser, ent)
+
+				// the horn may have been a few updates ago, work back from how long the game has been going
+				if started, ok := ent.GetFloat32("m_pGameRules.m_flGameStartTime"); ok && started > 0 && info.HasClock {
+					info.StartTime = HornTick(parser.Tick, info.StartClock, started)
+					info.StartClock = started
+				}
+			}
+
+			winner, ok := ent.GetInt32("m_pGameRules.m_nGameWinner")
+			hasWinner = hasWinner || ok
+			info.SetWinner(winner, parser.Tick) // also set when a team calls GG and leaves, unlike the ancient dying
+
+			if matchID, ok := ent.GetUint64("m_pGameRules.m_unMatchID64"); ok {
+				info.MatchID = matchID
+			}
+
+			if gameMode, ok := ent.GetInt32("m_pGameRules.m_iGameMode"); ok {
+				info.GameMode = gameMode
+			}
+
+			if lobbyType, ok := ent.GetUint32("m_pGameRules.m_lobbyType"); ok {
+				info.LobbyType = int32(lobbyType)
+			}
+		}
 
 		if winningTeam != 0 {
-			if teamIndex != 0 && len(top3) > 0 {
+			if info.TeamIndex != 0 && selected {
 				parser.Stop()
-			} else if classname == "CDOTA_PlayerResource" {
-				for i := (winningTeam - 2) * 5; i < (winningTeam-1)*5; i++ {
-					id := fmt.Sprintf("%04d", i)
-	
-					if kills, ok := ent.GetInt32("m_vecPlayerTeamData." + id + ".m_iKills"); ok { // kill count
-						if name, ok := ent.GetString("m_vecPlayerData." + id + ".m_iszPlayerName"); ok { // name
-							if len(top3) < 3 {
-								top3[i] = &TopPlayer{kills, name}
-							} else {
-								minIndex := MinIndex(top3)
-	
-								if minPlayer, _ := top3[minIndex]; minPlayer.Kills < kills { // higher than lowest top 3 player, replace
-									delete(top3, minIndex)
-									top3[i] = &TopPlayer{kills, name}
-								}
-							}
-						}
-					}
+			} else if classname == "CDOTA_PlayerResource" && !selected {
+				for _, player := range selector.Select(ReadPlayers(parser, ent, teamData, info.StartTime), winningTeam) {
+					info.Top[player.ID] = player
 				}
-			} else if (winningTeam == 2 && classname == "CDOTA_DataRadiant") || classname == "CDOTA_DataDire" {
-				teamIndex = ent.GetIndex()
+
+				selected = true
+			} else if (winningTeam == 2 && classname == "CDOTA_DataRadiant") || (winningTeam == 3 && classname == "CDOTA_DataDire") {
+				info.TeamIndex = ent.GetIndex()
+			}
+		} else if info.StartTime == 0 && !hasState && classname == RUNE { // fallback for replays without game state
+			info.StartTime = parser.Tick
+
+			if rules := parser.FindEntity(gamerules); rules != nil {
+				info.StartClock, info.HasClock = GameTime(parser, rules)
 			}
-		} else if startTime == 0 && classname == RUNE {
-			startTime = parser.Tick
 		} else if IsHero(ent) {
 			name := GetHammerName(parser, ent)
 
-			if _, ok := teamComposition[name]; !ok {
+			if _, ok := info.Teams[name]; !ok {
 				if team, ok := ent.GetUint64("m_iTeamNum"); ok {
-					teamComposition[name] = team
+					info.Teams[name] = team
 				}
 			}
-		} else if classname == ANCIENT {
+
+			if id, ok := ent.GetInt32("m_iPlayerID"); ok {
+				info.Lanes[id] = lanes.Sample(parser.Tick, info.StartTime, id, ent)
+			}
+		} else if classname == ANCIENT && !hasWinner { // fallback for replays without a winner in the gamerules
 			if health, ok := ent.GetInt32("m_iHealth"); ok && health <= 0 { // ancient dead?
 				if team, ok := ent.GetUint64("m_iTeamNum"); ok {
-					winningTeam = int32(team) ^ 1 // get the enemy team of the team whose ancient just died (2 ^ 1 == 3, 3 ^ 1 == 2)
+					info.WinningTeam = int32(team) ^ 1 // get the enemy team of the team whose ancient just died (2 ^ 1 == 3, 3 ^ 1 == 2)
+					info.EndTime = parser.Tick
 				} else {
-					log.Fatalf("Error retrieving m_iTeamNum from ancient (tick %d)\n", parser.Tick)
+					return fmt.Errorf("error retrieving m_iTeamNum from ancient (tick %d)", parser.Tick)
 				}
 			}
 		}
@@ -90,39 +161,266 @@ func FirstPass(filehandle *os.File) (map[int32]*TopPlayer, uint32, int32) {
 		return nil
 	})
 
-	parser.Start()
+	return info
+}
+
+/*
+	Retrieves the players selector picks and also gets the start time of the match (horn) in ticks. Matches that never get a
+	winner return a *SkipError.
+*/
+func FirstPass(ctx context.Context, corpora *Corpora, demo io.Reader, selector PlayerSelector) (*MatchInfo, error) {
+	parser, err := CreateParser(demo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := WatchMatch(ctx, parser, selector)
+
+	if err := parser.Start(); err != nil {
+		return nil, err
+	}
+
+	if info.WinningTeam == 0 && ctx.Err() == nil {
+		return nil, &SkipError{Reason: "match has no winner"}
+	}
+
+	corpora.Teams = append(corpora.Teams, info.Teams)
+
+	return info, ctx.Err()
+}
+
+/* A point in a replay: its tick and, if the gamerules network it, their clock. */
+type Moment struct {
+	Tick     uint32
+	Clock    float32 // seconds, stops during pauses
+	HasClock bool
+	Paused   bool
+}
+
+/* Decides where the examples of each player go, how their time is measured and which lane front they see. */
+type ExampleSink interface {
+	Corpus(playerID int32, hero string, team uint64) (*Corpus, error) // nil if the player's actions should be ignored
+	Time(now Moment) float32
+	CreepFront(playerID int32, team uint64, fronts *LaneFronts, now Moment) float32
+}
+
+/* What RecordExamples records. */
+type ExampleOptions struct {
+	DropPaused bool          // no examples while the game is paused
+	Sequences  bool          // group chains of queued orders into one move example
+	Features   FeatureSchema // optional feature groups of move examples
+}
+
+/* Whether examples made now are dropped. now is only asked when they could be, since it looks the gamerules up. */
+func (options ExampleOptions) Drops(now func() Moment) bool {
+	return options.DropPaused && now().Paused
+}
+
+/* Sends the examples of the first pass' selected players to corpora. */
+type selectedPlayers struct {
+	corpora *Corpora
+	info    *MatchInfo
+}
+
+func (sink *selectedPlayers) Corpus(playerID int32, hero string, team uint64) (*Corpus, error) {
+	if _, ok := sink.info.Top[playerID]; !ok {
+		return nil, nil
+	}
+
+	teams, err := sink.corpora.GetCorpus(hero)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return teams[team-2], nil
+}
 
-	corpora.Teams = append(corpora.Teams, teamComposition)
+func (sink *selectedPlayers) Time(now Moment) float32 {
+	if now.HasClock && sink.info.HasClock {
+		return ClockTime(now.Clock, sink.info.StartClock)
+	}
 
-	return top3, startTime, teamIndex
+	return DotaTime(now.Tick, sink.info.StartTime)
+}
+
+func (sink *selectedPlayers) CreepFront(playerID int32, team uint64, fronts *LaneFronts, now Moment) float32 {
+	return fronts.Amount(team, sink.info.Lanes[playerID])
 }
 
 /*
-	Tracks the actions of the top 3 players on the winning team and constructs examples out of each action.
+	Tracks the actions of the players the first pass selected and constructs examples out of each action.
 */
-func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32, teamIndex int32) {
-	parser := CreateParser(filehandle)
+func SecondPass(ctx context.Context, corpora *Corpora, demo io.Reader, info *MatchInfo, options ExampleOptions) error {
+	parser, err := CreateParser(demo)
+
+	if err != nil {
+		return err
+	}
+
+	flush := RecordExamples(ctx, parser, &selectedPlayers{corpora, info}, options)
+
+	if err := parser.Start(); err != nil {
+		return err
+	}
+
+	return FirstError(flush(), ctx.Err())
+}
+
+/*
+	Where a player's team thinks the other nine heroes are, in the order StartMoveThink lists them: the four allies, then the
+	five enemies, each by player ID like GetTeamPlayers() returns them (player IDs 0-4 are Radiant, 5-9 Dire). The slots of
+	players who don't have a hero are padded with their team's home shop, which is also where the bots put heroes they know
+	nothing about.
+*/
+func OtherHeroes(parser *manta.Parser, resource *manta.Entity, vision *Vision, playerID int32, team uint64) ([9]float32, [9]float32) {
+	var x, y [9]float32
+
+	ally, enemy := 0, 4 // next free slots
+
+	for id := int32(0); id < 10; id++ {
+		heroTeam := uint64(2 + id/5)
+
+		var slot int
+
+		if id == playerID {
+			continue
+		} else if heroTeam == team && ally < 4 {
+			slot, ally = ally, ally+1
+		} else if heroTeam != team && enemy < 9 {
+			slot, enemy = enemy, enemy+1
+		} else { // playerID isn't on team
+			continue
+		}
 
+		shop := HOME_SHOPS[heroTeam]
+		x[slot], y[slot] = RemapX(shop[0]), RemapY(shop[1])
+
+		if resource != nil {
+			if handle, ok := resource.GetUint64(fmt.Sprintf("m_vecPlayerTeamData.%04d.m_hSelectedHero", id)); ok && parser.FindEntity(Handle(handle)) != nil {
+				loc := vision.Location(parser, Handle(handle), heroTeam, team, parser.Tick)
+				x[slot], y[slot] = loc[0], loc[1]
+			}
+		}
+	}
+
+	return x, y
+}
+
+/*
+	Constructs examples out of the actions of every player sink gives a corpus for. Like for the bots, other heroes are only where
+	the player's team could see them (see Vision). Returns what writes the move examples still waiting for queued orders, to be
+	called once the parser is done.
+*/
+func RecordExamples(ctx context.Context, parser *manta.Parser, sink ExampleSink, options ExampleOptions) func() error {
 	heroes := make(map[string]*Hero)
-	//creep_front := [2]float32{
+	teamData := make(map[uint64]int32) // team -> entindex of its CDOTA_DataRadiant/CDOTA_DataDire
+	resource := int32(-1)              // entindex of CDOTA_PlayerResource
+	gamerules := int32(-1)
+	fronts := NewLaneFronts()
+	vision := NewVision()
+	units := NewUnitIndex()
+	objectives := NewObjectives()
+	modifiers := NewModifiers(options.Features)
+	chains := newOrderChains(options.Sequences)
+
+	/* The game clock right now. */
+	now := func() Moment {
+		moment := Moment{Tick: parser.Tick}
+
+		if rules := parser.FindEntity(gamerules); rules != nil {
+			moment.Clock, moment.HasClock = GameTime(parser, rules)
+			moment.Paused, _ = rules.GetBool("m_pGameRules.m_bGamePaused")
+		}
+
+		return moment
+	}
+
+	/* Where an example recorded now for a player comes from. */
+	provenance := func(playerID int32) *Provenance {
+		source := &Provenance{PlayerID: playerID, Tick: parser.Tick}
+
+		if resourceEnt := parser.FindEntity(resource); resourceEnt != nil {
+			steamID, _ := resourceEnt.GetUint64(fmt.Sprintf("m_vecPlayerData.%04d.m_iPlayerSteamID", playerID))
+			source.AccountID = AccountOf(steamID)
+		}
+
+		return source
+	}
+
+	/*
+		Players an order came from: the one whose player entity sent it and the ones controlling the heroes it was given to, so
+		orders to other units count too.
+	*/
+	orderPlayers := func(msg *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) []int32 {
+		players := []int32{}
+
+		if player := parser.FindEntity(msg.GetEntindex()); player != nil {
+			if id, ok := player.GetInt32("m_iPlayerID"); ok {
+				players = append(players, id)
+			}
+		}
+
+		for _, unit := range msg.GetUnits() {
+			if entity := parser.FindEntity(unit); entity != nil && IsHero(entity) {
+				if id, ok := entity.GetInt32("m_iPlayerID"); ok {
+					players = append(players, id)
+				}
+			}
+		}
+
+		return players
+	}
+
+	parser.OnModifierTableEntry(func(msg *dota.CDOTAModifierBuffTableEntry) error {
+		return modifiers.Update(parser, msg)
+	})
+
+	parser.OnEntity(func(ent *manta.Entity, op manta.EntityOp) error {
+		if ctx.Err() != nil { // cancelled
+			parser.Stop()
+			return nil
+		}
+
+		switch ent.GetClassName() {
+		case "CDOTA_DataRadiant":
+			teamData[2] = ent.GetIndex()
+		case "CDOTA_DataDire":
+			teamData[3] = ent.GetIndex()
+		case "CDOTA_PlayerResource":
+			resource = ent.GetIndex()
+		case GAMERULES:
+			gamerules = ent.GetIndex()
+		case LANE_CREEP:
+			fronts.Update(ent, op, parser.Tick)
+		}
+
+		units.Update(ent, op)
+		objectives.Update(parser, ent, op, now)
+
+		if op.Flag(manta.EntityOpDeleted) {
+			modifiers.Forget(ent.GetIndex())
+		}
 
-	parser.OnEntity(func(ent *manta.Entity, _ manta.EntityOp) error {
 		if IsHero(ent) {
+			vision.Update(ent, parser.Tick)
+
 			hero, ok := heroes[ent.GetClassName()]
 
 			if !ok {
 				team, _ := ent.GetUint64("m_iTeamNum")
 				heroes[ent.GetClassName()] = &Hero{team, ent.GetIndex(), make(map[int]struct{}), 0}
-			} else if hero.Entindex == ent.GetIndex() && parser.Tick / ITEM_PERIOD > hero.LastInventorySave {
+			} else if hero.Entindex == ent.GetIndex() && parser.Tick / ITEM_PERIOD > hero.LastInventorySave && !options.Drops(now) {
 				id, ok := ent.GetInt32("m_iPlayerID")
+				name := GetHammerName(parser, ent)
+				team, _ := ent.GetUint64("m_iTeamNum")
 
-				if _, isTop3 := top3[id]; ok && isTop3 {
-					name := GetHammerName(parser, ent)
-
-					team, _ := ent.GetUint64("m_iTeamNum")
-					corpus := corpora.GetCorpus(name)[team-2]
-
+				if corpus, err := sink.Corpus(id, name, team); err != nil {
+					return err
+				} else if ok && corpus != nil {
 					example := &BuildExample{}
+					example.CurrentInventory = make(map[int]struct{})
 
 					for itemCount := 0; ; itemCount++ {
 						if itemHandle, ok := ent.GetUint64(fmt.Sprintf("m_hItems.%04d", itemCount)); ok {
@@ -143,17 +441,19 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 
 					hero.PreviousItems = example.CurrentInventory
 
-					if len(example.NewItems) > 0 {
+					if teamEnt := parser.FindEntity(teamData[team]); len(example.NewItems) > 0 && teamEnt != nil {
 						teamID := id % 5
-						teamEnt := parser.FindEntity(teamIndex)
 
 						reliableGold, _ := teamEnt.GetInt32(fmt.Sprintf("m_vecDataTeam.%04d.m_iReliableGold", teamID))
 						unreliableGold, _ := teamEnt.GetInt32(fmt.Sprintf("m_vecDataTeam.%04d.m_iUnreliableGold", teamID))
 
-						example.DotaTime = DotaTime(parser.Tick, startTime)
+						example.DotaTime = sink.Time(now())
 						example.Gold = float32(reliableGold + unreliableGold) / 10000.0
+						example.Provenance = provenance(id)
 
-						WriteToCorpus(example, corpus.Item)
+						if err := WriteToCorpus(example, corpus.Item); err != nil {
+							return err
+						}
 
 						hero.LastInventorySave = parser.Tick / ITEM_PERIOD
 					}
@@ -166,6 +466,19 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 
 	/* Callback for every unit action. */
 	parser.Callbacks.OnCDOTAUserMsg_SpectatorPlayerUnitOrders(func(msg *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) error {
+		// An unqueued order ends the player's chain even if no example is recorded for it
+		if !msg.GetQueue() {
+			for _, playerID := range orderPlayers(msg) {
+				if err := chains.flush(playerID); err != nil {
+					return err
+				}
+			}
+		}
+
+		if options.Drops(now) {
+			return nil
+		}
+
 		if len(msg.GetUnits()) > 0 {
 			for _, unit := range msg.GetUnits() { // multiple units can be selected
 				entity := parser.FindEntity(unit)
@@ -173,13 +486,13 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 				if entity != nil {
 					if IsHero(entity) { // replace with any criterion for producing examples
 						id, ok := entity.GetInt32("m_iPlayerID")
+						name := GetHammerName(parser, entity)
+						team, _ := entity.GetUint64("m_iTeamNum")
 
-						if _, isTop3 := top3[id]; ok && isTop3 {
+						if corpus, err := sink.Corpus(id, name, team); err != nil {
+							return err
+						} else if ok && corpus != nil {
 							/* Construct feature vector. */
-							name := GetHammerName(parser, entity)
-
-							team, _ := entity.GetUint64("m_iTeamNum")
-							corpus := corpora.GetCorpus(name)[team-2]
 							abilityPrefix := strings.SplitN(name, "dota_hero_", 2)[1]
 
 							example := &MoveExample{}
@@ -214,12 +527,7 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 									} else {
 										switch targetEnt.GetClassName() {
 										case LANE_CREEP:
-											if ability == 0 { // don't bother generating examples for regular attacks
-												continue
-											} else {
-												example.Target = TargetLane
-											}
-
+											example.Target = TargetLane
 										case JUNGLE_CREEP:
 											example.Target = TargetJungle
 										case TOWER:
@@ -261,9 +569,9 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 										}
 									}
 								} else {
-									// Could be caused by leveling an ability? Silence for now
-									//log.Fatalf("Error retrieving an ability (entindex %d, tick %d, player %v)\n", ability, parser.Tick, entity)
-									continue
+									// Could be caused by leveling an ability? Record the order without knowing what was used
+									example.AbilityUsed = 0
+									example.ItemUsed = 0
 								}
 							}
 
@@ -274,38 +582,41 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 							level, _ := entity.GetInt32("m_iCurrentLevel")
 
 							movePos := msg.GetPosition()
+							creepFront := sink.CreepFront(id, team, fronts, now())
 
-							example.DotaTime = DotaTime(parser.Tick, startTime)   // DotaTime()
+							example.DotaTime = sink.Time(now())                   // DotaTime()
 							example.Health = float32(health) / float32(maxHealth) // :GetHealth()
 							example.Mana = mana / maxMana                         // :GetMana()
 							example.Level = float32(level) / 25.0                 // :GetCurrentLevel()
-							example.CreepFront = 0.0                              // GetLaneFrontAmount() FIXME
+							example.CreepFront = creepFront                       // GetLaneFrontAmount(GetTeam(), :GetAssignedLane(), true)
+							example.Provenance = provenance(id)
+							example.Provenance.Order = msg.GetOrderType()
+							example.Action = OrderAction(msg.GetOrderType())
+
+							if msg.GetQueue() {
+								example.Queued = 1
+							}
 
 							// my position
 							example.CurrentX = coords[0]
 							example.CurrentY = coords[1]
 
-							// everyone else's position
-							ally := 0
-							enemy := 4
+							// everyone else's position, as far as our team can see them
+							example.OtherX, example.OtherY = OtherHeroes(parser, parser.FindEntity(resource), vision, id, team)
 
-							for _, hero := range heroes {
-								if hero.Entindex == entity.GetIndex() {
-									continue
-								}
+							example.Extra = options.Features.Extract(&FeatureContext{
+								Parser:   parser,
+								Hero:     entity,
+								PlayerID: id,
+								Team:     team,
+								Resource: parser.FindEntity(resource),
+								TeamData: parser.FindEntity(teamData[team]),
+								Units:    units,
+								Now:      now(),
 
-								loc := GetLocation(parser.FindEntity(hero.Entindex))
-
-								if hero.Team == team {
-									example.OtherX[ally] = loc[0]
-									example.OtherY[ally] = loc[1]
-									ally++
-								} else {
-									example.OtherX[enemy] = loc[0]
-									example.OtherY[enemy] = loc[1]
-									enemy++
-								}
-							}
+								Objectives: objectives,
+								Modifiers:  modifiers,
+							})
 
 							// Retrieve ability cooldowns
 							abilityID := 0
@@ -331,12 +642,18 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 								}
 							}
 
-							// Retrieve current items
+							// Retrieve current items, with their cooldowns, charges and slots
 							for itemCount := 0; ; itemCount++ {
 								if itemHandle, ok := entity.GetUint64(fmt.Sprintf("m_hItems.%04d", itemCount)); ok {
 									if item := parser.FindEntity(Handle(itemHandle)); item != nil {
 										if name := GetHammerName(parser, item); name != "" {
+											cooldown, _ := item.GetFloat32("m_fCooldown")
+											charges, _ := item.GetInt32("m_iCurrentCharges")
+
 											example.CurrentItems = append(example.CurrentItems, GetID(corpus.ObservedItems, name))
+											example.ItemCooldowns = append(example.ItemCooldowns, ItemCooldown(cooldown, now()))
+											example.ItemCharges = append(example.ItemCharges, float32(charges)/CHARGES_SCALE)
+											example.ItemSlots = append(example.ItemSlots, ItemSlotType(itemCount))
 										}
 									}
 								} else {
@@ -349,7 +666,9 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 								example.MoveY = RemapY(movePos.GetY())
 							}
 
-							WriteToCorpus(example, corpus.Move)
+							if err := chains.Add(id, corpus, example, msg.GetQueue()); err != nil {
+								return err
+							}
 						}
 					}
 				}
@@ -359,36 +678,5 @@ func SecondPass(filehandle *os.File, top3 map[int32]*TopPlayer, startTime uint32
 		return nil
 	})
 
-	parser.Start()
-}
-
-func Start() {
-	defer corpora.CloseCorpora()
-
-	log.SetOutput(os.Stdout)
-
-	if err := os.Mkdir("data", 493); err != nil && !os.IsExist(err) {
-		log.Fatal("Can't create data folder")
-	}
-
-	if len(os.Args) == 1 {
-		log.Fatal("usage: corpus_build <demos...>")
-	}
-
-	for i, demoName := range os.Args[1:] {
-		log.Printf("Demo %d (%s)\n", i+1, demoName)
-
-		filehandle := OpenDemo(demoName)
-		defer filehandle.Close()
-
-		top3, startTime, teamIndex := FirstPass(filehandle) // retrieve top 3 players
-
-		for id, player := range top3 {
-			log.Println(id, player.Name, player.Kills)
-		}
-
-		filehandle.Seek(0, 0) // go back to beginning of demo
-
-		SecondPass(filehandle, top3, startTime, teamIndex) // make examples
-	}
+	return chains.Flush
 }
@@ -0,0 +1,66 @@
+package builder
+
+import "testing"
+
+func TestMatchInfoSetWinner(t *testing.T) {
+	info := &MatchInfo{}
+
+	// The gamerules network 5 (no team) while the game's still going
+	info.SetWinner(5, 100)
+	info.SetWinner(0, 200)
+
+	if info.WinningTeam != 0 || info.EndTime != 0 {
+		t.Errorf("winner %d at tick %d before either team won", info.WinningTeam, info.EndTime)
+	}
+
+	info.SetWinner(3, 300)
+	info.SetWinner(3, 400) // the gamerules keep updating after the game is over
+	info.SetWinner(2, 500)
+
+	if info.WinningTeam != 3 || info.EndTime != 300 {
+		t.Errorf("winner %d at tick %d, expected 3 at tick 300", info.WinningTeam, info.EndTime)
+	}
+}
+
+func TestSelectedPlayersTime(t *testing.T) {
+	sink := &selectedPlayers{info: &MatchInfo{StartTime: 3000, StartClock: 100, HasClock: true}}
+
+	// A minute of play, then a minute paused: the clock stops, the ticks don't
+	before := sink.Time(Moment{Tick: 3000 + 60*TICKRATE, Clock: 160, HasClock: true})
+	after := sink.Time(Moment{Tick: 3000 + 120*TICKRATE, Clock: 160, HasClock: true, Paused: true})
+
+	if before != ClockTime(160, 100) || after != before {
+		t.Errorf("timed %f before the pause and %f after it, expected %f for both", before, after, ClockTime(160, 100))
+	}
+
+	if time := sink.Time(Moment{Tick: 3000 + 60*TICKRATE}); time != DotaTime(3000+60*TICKRATE, 3000) {
+		t.Errorf("moment without a clock timed %f", time)
+	}
+
+	// Clock times can't be compared to a horn that was only timed by tick
+	sink.info.HasClock = false
+
+	if time := sink.Time(Moment{Tick: 3000 + 60*TICKRATE, Clock: 160, HasClock: true}); time != DotaTime(3000+60*TICKRATE, 3000) {
+		t.Errorf("moment timed %f without a clock at the horn", time)
+	}
+}
+
+func TestExampleOptionsDrops(t *testing.T) {
+	asked := false
+	paused := func() Moment {
+		asked = true
+		return Moment{Paused: true}
+	}
+
+	if (ExampleOptions{}).Drops(paused) || asked {
+		t.Errorf("dropped examples, or looked at the clock, without -drop-paused")
+	}
+
+	if !(ExampleOptions{DropPaused: true}).Drops(paused) {
+		t.Errorf("kept examples made while paused")
+	}
+
+	if (ExampleOptions{DropPaused: true}).Drops(func() Moment { return Moment{} }) {
+		t.Errorf("dropped examples made while playing")
+	}
+}
@@ -0,0 +1,652 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const USAGE = `usage: corpus_builder <command> [flags] [args...]
+
+commands:
+  build     parse demos and write corpora
+  inspect   print what the first pass finds in demos without writing anything
+  stats     count the examples in a corpora folder
+  validate  check that a corpora folder is complete and consistent
+  merge     combine several corpora folders into one
+
+Demos can be plain .dem files, compressed (.dem.bz2, .dem.gz, .dem.zst), or bundled in
+.tar (.tar.gz, .tgz, .tar.bz2, .tbz2, .tar.zst) and .zip archives.
+
+Demos can also be found with glob patterns (a ** matches any number of folders), -input-dir and
+-from-list. Demos that are identical to, or record the same match as, one already built are skipped.
+Matches can be filtered by game mode, lobby type and patch (-modes, -lobby-types, -min-patch,
+-max-patch); excluded demos are listed in summary.json. With -by-patch every patch gets its own
+corpora and vocabulary in <out>/<patch>, which stats, validate and merge take like any corpora folder.
+Move examples can carry optional hero state, nearby unit, objective and modifier features (-features,
+-nearby-radii, -modifiers), laid out in features.json; the modifier vocabulary goes next to the
+-vocab file as modifier_data.lua.
+
+Run corpus_builder <command> -h for the flags of a command.
+`
+
+const DEFAULT_TOP_K = 3
+
+/* Options shared by the subcommands. */
+type Config struct {
+	OutputDir string   // folder the per-hero corpora go into
+	VocabPath string   // where ability_data.lua is written
+	Players   string   // player selection policy
+	TopK      int      // how many players the policy keeps
+	Accounts  []uint32 // Steam accounts the accounts policy keeps
+	Allow     []uint32 // if set, only these Steam accounts can be selected
+	Deny      []uint32 // Steam accounts that are never selected
+	Jobs      int      // demos parsed concurrently
+	KeepGoing bool     // skip broken demos instead of stopping the build
+	Rebuild   bool     // ignore the manifest and start the corpora from scratch
+
+	InputDirs []string // folders searched recursively for demos
+	FromLists []string // files listing demos, "-" for stdin
+
+	Patches PatchTable // names the patches of server builds
+	ByPatch bool       // corpora and vocabularies go into a folder per patch
+
+	Filter MatchFilter // which matches are built
+
+	SinglePass       bool // parse each demo once, buffering every player's examples
+	SinglePassMemory int  // bytes of examples the single pass keeps in memory, across all demos parsed at once
+
+	DropPaused bool           // no examples while the game is paused
+	Sequences  bool           // group chains of queued orders into one move example
+	Features   FeatureOptions // optional feature groups of move examples
+
+	Verbose bool
+}
+
+var verbose bool
+
+/* Logs only with -v. */
+func Debugf(format string, args ...interface{}) {
+	if verbose {
+		log.Printf(format, args...)
+	}
+}
+
+/* A flag that can be given several times. */
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+/* A flag of comma separated values, which can also be given several times. */
+type commaList []string
+
+func (list *commaList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *commaList) Set(value string) error {
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			*list = append(*list, field)
+		}
+	}
+
+	return nil
+}
+
+/* A flag of comma separated radii in world units, up to what the bot API can look. */
+type radiusList []float32
+
+func (list *radiusList) String() string {
+	radii := make([]string, len(*list))
+
+	for i, radius := range *list {
+		radii[i] = strconv.FormatFloat(float64(radius), 'g', -1, 32)
+	}
+
+	return strings.Join(radii, ",")
+}
+
+func (list *radiusList) Set(value string) error {
+	for _, field := range strings.Split(value, ",") {
+		radius, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+
+		if err != nil || radius <= 0 || radius > MAX_NEARBY_RADIUS {
+			return fmt.Errorf("invalid radius %q, radii go from 0 to %g", field, MAX_NEARBY_RADIUS)
+		}
+
+		*list = append(*list, float32(radius))
+	}
+
+	return nil
+}
+
+/* A comma separated flag of Steam accounts, as account IDs or 64 bit Steam IDs. */
+type accountList []uint32
+
+func (list *accountList) String() string {
+	accounts := make([]string, len(*list))
+
+	for i, account := range *list {
+		accounts[i] = strconv.FormatUint(uint64(account), 10)
+	}
+
+	return strings.Join(accounts, ",")
+}
+
+func (list *accountList) Set(value string) error {
+	for _, field := range strings.Split(value, ",") {
+		account, err := ParseAccount(field)
+
+		if err != nil {
+			return err
+		}
+
+		*list = append(*list, account)
+	}
+
+	return nil
+}
+
+/* A flag naming a file of Steam accounts, which is read right away. */
+type accountFile []uint32
+
+func (list *accountFile) String() string {
+	return (*accountList)(list).String()
+}
+
+func (list *accountFile) Set(path string) error {
+	accounts, err := ReadAccounts(path)
+
+	if err != nil {
+		return err
+	}
+
+	*list = append(*list, accounts...)
+	return nil
+}
+
+/* A flag naming a patch table file, which is read right away. */
+type patchFile PatchTable
+
+func (table *patchFile) String() string {
+	return ""
+}
+
+func (table *patchFile) Set(path string) error {
+	patches, err := LoadPatches(path)
+
+	if err != nil {
+		return err
+	}
+
+	*table = patchFile(patches)
+	return nil
+}
+
+/* A comma separated flag of game modes or lobby types, by name or number. */
+type idSet struct {
+	ids   *map[int32]bool
+	names map[string]int32
+}
+
+func (set idSet) String() string {
+	if set.ids == nil {
+		return ""
+	}
+
+	var ids []string
+
+	for id := range *set.ids {
+		ids = append(ids, strconv.Itoa(int(id)))
+	}
+
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func (set idSet) Set(value string) error {
+	ids, err := ParseIDs(value, set.names)
+
+	if err != nil {
+		return err
+	}
+
+	if *set.ids == nil {
+		*set.ids = ids
+	} else {
+		for id := range ids {
+			(*set.ids)[id] = true
+		}
+	}
+
+	return nil
+}
+
+/* Names of an ID table, for flag usage. */
+func idNames(names map[string]int32) string {
+	var list []string
+
+	for name := range names {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+/* Registers the flags a subcommand uses. */
+func (config *Config) inputFlags(flags *flag.FlagSet) {
+	flags.Var((*stringList)(&config.InputDirs), "input-dir", "folder to search recursively for demos (repeatable)")
+	flags.Var((*stringList)(&config.FromLists), "from-list", "file listing demos or patterns one per line, - for stdin (repeatable)")
+}
+
+func (config *Config) outputFlags(flags *flag.FlagSet) {
+	flags.StringVar(&config.OutputDir, "out", "data", "corpora output folder")
+}
+
+func (config *Config) patchFlags(flags *flag.FlagSet) {
+	flags.Var((*patchFile)(&config.Patches), "patches", "file naming the patch of server builds, one \"<first build> <patch>\" per line")
+}
+
+func (config *Config) filterFlags(flags *flag.FlagSet) {
+	flags.Var(idSet{&config.Filter.Modes, GAME_MODES}, "modes", "comma separated game modes to build, by number or name ("+idNames(GAME_MODES)+")")
+	flags.Var(idSet{&config.Filter.LobbyTypes, LOBBY_TYPES}, "lobby-types", "comma separated lobby types to build, by number or name ("+idNames(LOBBY_TYPES)+")")
+	flags.StringVar(&config.Filter.MinPatch, "min-patch", "", "oldest patch to build, such as 7.33 (needs -patches)")
+	flags.StringVar(&config.Filter.MaxPatch, "max-patch", "", "newest patch to build; 7.33 includes 7.33a, 7.33b... (needs -patches)")
+}
+
+func (config *Config) vocabFlags(flags *flag.FlagSet) {
+	flags.StringVar(&config.VocabPath, "vocab", "ability_data.lua", "path of the generated ability/item vocabulary")
+}
+
+func (config *Config) playerFlags(flags *flag.FlagSet) {
+	flags.StringVar(&config.Players, "players", PlayersTopKills, "player selection policy ("+strings.Join(SELECTION_POLICIES, ", ")+")")
+	flags.IntVar(&config.TopK, "top", DEFAULT_TOP_K, "number of winners the top-* policies keep")
+	flags.Var((*accountList)(&config.Accounts), "accounts", "comma separated Steam accounts the accounts policy keeps")
+	flags.Var((*accountFile)(&config.Allow), "allow", "file of Steam accounts, one per line; only these players can be selected (repeatable)")
+	flags.Var((*accountFile)(&config.Deny), "deny", "file of Steam accounts, one per line, that are never selected (repeatable)")
+}
+
+func (config *Config) verboseFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&config.Verbose, "v", false, "verbose logging")
+}
+
+/* Parses a subcommand's flags and checks them. */
+func (config *Config) parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if config.Players != "" {
+		if _, err := NewSelector(config.Players, config.TopK, config.Accounts); err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(config.Players, "top-") && config.TopK < 1 {
+			return errors.New("-top must be at least 1")
+		}
+
+		if config.Players == PlayersAccounts && len(config.Accounts) == 0 {
+			return errors.New("-players accounts needs -accounts")
+		}
+	}
+
+	if config.Filter.ByPatch() && len(config.Patches) == 0 {
+		return errors.New("-min-patch and -max-patch need -patches")
+	}
+
+	if config.ByPatch && len(config.Patches) == 0 {
+		return errors.New("-by-patch needs -patches")
+	}
+
+	if len(config.Features.NearbyRadii) == 0 {
+		config.Features.NearbyRadii = DEFAULT_NEARBY_RADII
+	}
+
+	if len(config.Features.Modifiers) == 0 {
+		config.Features.Modifiers = DEFAULT_MODIFIERS
+	}
+
+	if config.Features.ModifierEnemies < 0 {
+		return errors.New("-modifier-enemies can't be negative")
+	}
+
+	if _, err := NewFeatureSchema(config.Features); err != nil {
+		return err
+	}
+
+	verbose = config.Verbose
+	return nil
+}
+
+/* The player selector the config asks for, with the allow and deny lists applied. */
+func (config *Config) Selector() (PlayerSelector, error) {
+	selector, err := NewSelector(config.Players, config.TopK, config.Accounts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Allow) > 0 || len(config.Deny) > 0 {
+		selector = NewAccountFilter(selector, config.Allow, config.Deny)
+	}
+
+	return selector, nil
+}
+
+/* The demos a subcommand was given, as arguments, -input-dir folders and -from-list files. */
+func (config *Config) demos(flags *flag.FlagSet) ([]string, error) {
+	demos, err := FindDemos(flags.Args(), config.InputDirs, config.FromLists)
+
+	if err == nil && len(demos) == 0 {
+		flags.Usage()
+		err = errors.New("no demos given")
+	}
+
+	return demos, err
+}
+
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: corpus_builder %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+/* build: the original corpus_builder behaviour. */
+func BuildCommand(args []string) error {
+	config := &Config{}
+	flags := newFlagSet("build", "[flags] [demos or patterns...]")
+
+	var memory int
+
+	config.inputFlags(flags)
+	config.outputFlags(flags)
+	config.patchFlags(flags)
+	config.filterFlags(flags)
+	config.vocabFlags(flags)
+	config.playerFlags(flags)
+	config.verboseFlags(flags)
+	flags.IntVar(&config.Jobs, "jobs", 1, "number of demos to parse concurrently")
+	flags.BoolVar(&config.KeepGoing, "keep-going", false, "skip demos that fail to parse and list them in errors.json")
+	flags.BoolVar(&config.Rebuild, "rebuild", false, "start from scratch instead of appending to the corpora in the manifest")
+	flags.BoolVar(&config.SinglePass, "single-pass", false, "parse each demo once instead of twice, buffering examples for every player")
+	flags.BoolVar(&config.DropPaused, "drop-paused", false, "don't record examples while the game is paused")
+	flags.BoolVar(&config.Sequences, "sequences", false, "group each chain of shift-queued orders into one move example, like ActionQueue_* gives them")
+	flags.Var((*commaList)(&config.Features.Groups), "features", "comma separated optional move feature groups ("+strings.Join(FeatureGroupNames(), ", ")+"), laid out in features.json")
+	flags.Var((*radiusList)(&config.Features.NearbyRadii), "nearby-radii", "comma separated radii the nearby feature group looks within (default "+(*radiusList)(&DEFAULT_NEARBY_RADII).String()+")")
+	flags.Var((*commaList)(&config.Features.Modifiers), "modifiers", "comma separated modifiers the modifiers feature group looks for (default "+strings.Join(DEFAULT_MODIFIERS, ",")+")")
+	flags.IntVar(&config.Features.ModifierEnemies, "modifier-enemies", DEFAULT_MODIFIER_ENEMIES, "how many of the nearest visible enemy heroes the modifiers feature group looks at besides the hero")
+	flags.BoolVar(&config.ByPatch, "by-patch", false, "write the corpora and vocabulary of every patch into <out>/<patch> (needs -patches)")
+	flags.IntVar(&memory, "single-pass-memory", SINGLE_PASS_MEMORY>>20, "megabytes of examples -single-pass keeps in memory, across all -jobs, before spilling to disk")
+
+	if err := config.parse(flags, args); err != nil {
+		return err
+	}
+
+	demos, err := config.demos(flags)
+
+	if err != nil {
+		return err
+	}
+
+	if config.Jobs < 1 {
+		return errors.New("-jobs must be at least 1")
+	}
+
+	config.SinglePassMemory = memory << 20
+
+	builder, err := NewBuilder(*config)
+
+	if err != nil {
+		return err
+	}
+
+	err = builder.BuildDemos(context.Background(), demos, config.Jobs)
+
+	return FirstError(builder.Close(), err) // corpora get closed properly even if a demo failed
+}
+
+/* inspect: runs the first pass and prints what it found. */
+func InspectCommand(args []string) error {
+	config := &Config{}
+	flags := newFlagSet("inspect", "[flags] [demos or patterns...]")
+
+	config.inputFlags(flags)
+	config.patchFlags(flags)
+	config.filterFlags(flags)
+	config.playerFlags(flags)
+	config.verboseFlags(flags)
+
+	if err := config.parse(flags, args); err != nil {
+		return err
+	}
+
+	corpora := NewCorpora("")
+
+	selector, err := config.Selector()
+
+	if err != nil {
+		return err
+	}
+
+	paths, err := config.demos(flags)
+
+	if err != nil {
+		return err
+	}
+
+	scratch, err := ioutil.TempDir("", "corpus_builder")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(scratch)
+
+	demos, err := ExpandDemos(paths, scratch)
+
+	if err != nil {
+		return err
+	}
+
+	for _, demo := range demos {
+		demoName := demo.Name
+		filehandle, err := demo.Open()
+
+		if err != nil {
+			return err
+		}
+
+		info, err := FirstPass(context.Background(), corpora, filehandle, selector)
+		filehandle.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", demoName, err)
+		}
+
+		fmt.Printf("%s\n\thorn tick: %d\n\twinning team: %d\n\twinning team data entity: %d\n", demoName, info.StartTime, info.WinningTeam, info.TeamIndex)
+		fmt.Printf("\tmatch: %d\n\tbuild: %d (patch %q)\n\tgame mode: %d\n\tlobby type: %d\n", info.MatchID, info.Build, config.Patches.Lookup(info.Build), info.GameMode, info.LobbyType)
+
+		if err := config.Filter.Check(info.GameMode, info.LobbyType, info.Build, config.Patches.Lookup(info.Build)); err != nil {
+			fmt.Printf("\texcluded: %s\n", err)
+		}
+
+		for hero, team := range info.Teams {
+			fmt.Printf("\tteam %d: %s\n", team, hero)
+		}
+
+		for id, player := range info.Top {
+			fmt.Printf("\tselected player %d: %s (account %d, %d/%d/%d, net worth %d, %.0f GPM)\n", id, player.Name, player.AccountID(), player.Kills, player.Deaths, player.Assists, player.NetWorth, player.GPM)
+		}
+	}
+
+	return nil
+}
+
+/* stats: counts examples per hero and team. */
+func StatsCommand(args []string) error {
+	config := &Config{}
+	flags := newFlagSet("stats", "[flags]")
+
+	config.outputFlags(flags)
+
+	if err := config.parse(flags, args); err != nil {
+		return err
+	}
+
+	dirs, err := ioutil.ReadDir(config.OutputDir)
+
+	if err != nil {
+		return err
+	}
+
+	totalMoves, totalItems := 0, 0
+
+	fmt.Printf("%-40s %4s %10s %10s\n", "hero", "team", "move", "items")
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == MATCHES_FOLDER {
+			continue
+		}
+
+		for team := 2; team <= 3; team++ {
+			moves, err := CountExamples(CorpusPath(config.OutputDir, dir.Name(), team, "move"))
+
+			if err != nil {
+				return err
+			}
+
+			items, err := CountExamples(CorpusPath(config.OutputDir, dir.Name(), team, "items"))
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%-40s %4d %10d %10d\n", dir.Name(), team, moves, items)
+
+			totalMoves += moves
+			totalItems += items
+		}
+	}
+
+	fmt.Printf("%-40s %4s %10d %10d\n", "total", "", totalMoves, totalItems)
+
+	return nil
+}
+
+/* validate: checks a corpora folder. */
+func ValidateCommand(args []string) error {
+	config := &Config{}
+	flags := newFlagSet("validate", "[flags]")
+
+	config.outputFlags(flags)
+
+	if err := config.parse(flags, args); err != nil {
+		return err
+	}
+
+	problems := ValidateCorpora(config.OutputDir)
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found in %s", len(problems), config.OutputDir)
+	}
+
+	fmt.Printf("%s is valid\n", config.OutputDir)
+	return nil
+}
+
+/* merge: combines corpora folders, remapping example IDs into one vocabulary. */
+func MergeCommand(args []string) error {
+	config := &Config{}
+	flags := newFlagSet("merge", "[flags] <corpora folders...>")
+
+	config.outputFlags(flags)
+	config.vocabFlags(flags)
+	config.verboseFlags(flags)
+
+	if err := config.parse(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no corpora folders given")
+	}
+
+	for _, source := range flags.Args() {
+		if filepath.Clean(source) == filepath.Clean(config.OutputDir) {
+			return fmt.Errorf("can't merge %s into itself", source)
+		}
+	}
+
+	config.Rebuild = true
+
+	builder, err := NewBuilder(*config)
+
+	if err != nil {
+		return err
+	}
+
+	for _, source := range flags.Args() {
+		Debugf("Merging %s\n", source)
+
+		if err = builder.Merge(source); err != nil {
+			break
+		}
+	}
+
+	return FirstError(builder.Close(), err)
+}
+
+var commands = map[string]func([]string) error{
+	"build":    BuildCommand,
+	"inspect":  InspectCommand,
+	"stats":    StatsCommand,
+	"validate": ValidateCommand,
+	"merge":    MergeCommand,
+}
+
+func Start() {
+	log.SetOutput(os.Stdout)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+
+	command, ok := commands[os.Args[1]]
+
+	if !ok {
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+
+	if err := command(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
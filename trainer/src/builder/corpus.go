@@ -3,26 +3,46 @@ package builder
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 )
 
 /* Represents the corpus of examples for one hero. */
 type Corpus struct {
-	MoveFile *os.File
-	ItemFile *os.File
+	MoveFile io.WriteCloser
+	ItemFile io.WriteCloser
 	Move     *bufio.Writer
 	Item     *bufio.Writer
 
-	ObservedItems           map[string]int
-	ObservedAbilities       []string
-	ObservedActiveAbilities map[string]int
-	ObservedActiveItems     map[string]int
-	ObservedHeroes          map[string]int
+	Observed
 }
 
-func NewCorpus(move *os.File, items *os.File) *Corpus {
+/* The vocabularies a corpus' example IDs refer to. */
+type Observed struct {
+	ObservedItems           map[string]int `json:"items"`
+	ObservedAbilities       []string       `json:"abilities"`
+	ObservedActiveAbilities map[string]int `json:"activeAbilities"`
+	ObservedActiveItems     map[string]int `json:"activeItems"`
+	ObservedHeroes          map[string]int `json:"heroes"`
+}
+
+func NewObserved() Observed {
+	return Observed{
+		make(map[string]int),
+		[]string{},
+		make(map[string]int),
+		make(map[string]int),
+		make(map[string]int),
+	}
+}
+
+func NewCorpus(move io.WriteCloser, items io.WriteCloser) *Corpus {
 	moveWriter := bufio.NewWriter(move)
 	itemsWriter := bufio.NewWriter(items)
 
@@ -34,51 +54,163 @@ func NewCorpus(move *os.File, items *os.File) *Corpus {
 		items,
 		moveWriter,
 		itemsWriter,
-		make(map[string]int),
-		[]string{},
-		make(map[string]int),
-		make(map[string]int),
-		make(map[string]int),
+		NewObserved(),
+	}
+}
+
+/* Wraps corpus files that already hold examples (see Corpora.Resume) so more can be added after them. */
+func ResumeCorpus(move *os.File, items *os.File, observed Observed) *Corpus {
+	return &Corpus{
+		move,
+		items,
+		bufio.NewWriter(move),
+		bufio.NewWriter(items),
+		observed,
+	}
+}
+
+/* Opens a corpus file for writing and checks it was closed, without changing it. Returns where its closing bracket starts. */
+func openClosed(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	end := make([]byte, 2)
+	size, err := file.Seek(-2, io.SeekEnd)
+
+	if err == nil {
+		_, err = io.ReadFull(file, end)
+	}
+
+	if err == nil && string(end) != "\n]" {
+		err = errors.New("corpus was never closed (missing ']')")
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return file, size, nil
+}
+
+/* Strips the closing bracket openClosed found, leaving the file positioned for more examples. */
+func stripClosing(file *os.File, size int64) error {
+	err := file.Truncate(size)
+
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", file.Name(), err)
 	}
+
+	return nil
 }
 
-func (corpus *Corpus) Close() {
-	corpus.Move.WriteString("\n]")
-	corpus.Move.Flush()
+/* Terminates and closes both corpus files, returning the first error hit (the files are closed either way). */
+func (corpus *Corpus) Close() error {
+	var errs []error
+
+	_, err := corpus.Move.WriteString("\n]")
+	errs = append(errs, err, corpus.Move.Flush())
 
-	corpus.Item.WriteString("\n]")
-	corpus.Item.Flush()
+	_, err = corpus.Item.WriteString("\n]")
+	errs = append(errs, err, corpus.Item.Flush())
 
-	corpus.MoveFile.Close()
-	corpus.ItemFile.Close()
+	errs = append(errs, corpus.MoveFile.Close(), corpus.ItemFile.Close())
+
+	return FirstError(errs...)
 }
 
 type Corpora struct {
+	Root    string                                    // folder the per-hero corpora are written to
+	Create  func(path string) (io.WriteCloser, error) // creates new corpus files, plain files if nil
+	Patch   string                                    // the patch all the examples are from, "" if they can be from any
 	Corpora map[string][]*Corpus
 	Teams   []map[string]uint64
+
+	Features FeatureSchema // what the move examples carry in Extra
+}
+
+/* Machine readable copy of the vocabularies and team compositions, written next to the corpora for merge and validate. */
+type Vocabulary struct {
+	Heroes map[string][]Observed `json:"heroes"`
+	Teams  []map[string]uint64   `json:"teams"`
+	Patch  string                `json:"patch,omitempty"`
+}
+
+const VOCABULARY_FILE = "vocabulary.json"
+
+/* Reads the vocabulary.json of a corpus folder. */
+func LoadVocabulary(root string) (*Vocabulary, error) {
+	file, err := os.Open(filepath.Join(root, VOCABULARY_FILE))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	vocab := &Vocabulary{}
+
+	if err := json.NewDecoder(file).Decode(vocab); err != nil {
+		return nil, fmt.Errorf("%s: %s", file.Name(), err)
+	}
+
+	return vocab, nil
+}
+
+func (vocab *Vocabulary) Write(root string) error {
+	if output, err := json.MarshalIndent(vocab, "", "\t"); err == nil {
+		return ioutil.WriteFile(filepath.Join(root, VOCABULARY_FILE), output, 0644)
+	} else {
+		return fmt.Errorf("failed to serialize vocabulary: %s", err)
+	}
+}
+
+/* Path of one of a hero's corpus files (kind is "move" or "items"). */
+func (corpora *Corpora) CorpusPath(hero string, team int, kind string) string {
+	return CorpusPath(corpora.Root, hero, team, kind)
+}
+
+func CorpusPath(root string, hero string, team int, kind string) string {
+	return filepath.Join(root, hero, fmt.Sprintf("%d_%sexamples", team, kind))
+}
+
+/* Creates a corpus file with Create, if set. */
+func (corpora *Corpora) create(path string) (io.WriteCloser, error) {
+	if corpora.Create != nil {
+		return corpora.Create(path)
+	}
+
+	return os.Create(path)
 }
 
 /* Returns or creates new corpus files for the given hero. */
-func (corpora *Corpora) GetCorpus(hero string) []*Corpus {
+func (corpora *Corpora) GetCorpus(hero string) ([]*Corpus, error) {
 	if corpus, ok := corpora.Corpora[hero]; ok {
-		return corpus
+		return corpus, nil
 	} else {
-		if err := os.Mkdir("data/"+hero, 493); err != nil && !os.IsExist(err) {
-			log.Fatal("Can't create data folder")
+		if err := os.MkdirAll(filepath.Join(corpora.Root, hero), 493); err != nil {
+			return nil, fmt.Errorf("can't create data folder for hero %s: %s", hero, err)
 		}
 
-		radiantMoveFile, radiantMoveErr := os.Create("data/" + hero + "/2_moveexamples")
-		radiantItemsFile, radiantItemErr := os.Create("data/" + hero + "/2_itemsexamples")
+		radiantMoveFile, radiantMoveErr := corpora.create(corpora.CorpusPath(hero, 2, "move"))
+		radiantItemsFile, radiantItemErr := corpora.create(corpora.CorpusPath(hero, 2, "items"))
 
-		if radiantMoveErr != nil || radiantItemErr != nil {
-			log.Fatalf("Error creating corpus files for hero %s, team Radiant\n", hero)
+		if err := FirstError(radiantMoveErr, radiantItemErr); err != nil {
+			return nil, fmt.Errorf("error creating corpus files for hero %s, team Radiant: %s", hero, err)
 		}
 
-		direMoveFile, direMoveErr := os.Create("data/" + hero + "/3_moveexamples")
-		direItemsFile, direItemErr := os.Create("data/" + hero + "/3_itemsexamples")
+		direMoveFile, direMoveErr := corpora.create(corpora.CorpusPath(hero, 3, "move"))
+		direItemsFile, direItemErr := corpora.create(corpora.CorpusPath(hero, 3, "items"))
 
-		if direMoveErr != nil || direItemErr != nil {
-			log.Fatalf("Error creating corpus files for hero %s, team Dire\n", hero)
+		if err := FirstError(direMoveErr, direItemErr); err != nil {
+			return nil, fmt.Errorf("error creating corpus files for hero %s, team Dire: %s", hero, err)
 		}
 
 		corpus := []*Corpus{
@@ -87,13 +219,138 @@ func (corpora *Corpora) GetCorpus(hero string) []*Corpus {
 		}
 
 		corpora.Corpora[hero] = corpus
-		return corpus
+		return corpus, nil
+	}
+}
+
+func NewCorpora(root string) *Corpora {
+	return &Corpora{Root: root, Corpora: make(map[string][]*Corpus), Features: FeatureSchema{[]FeatureGroup{}}}
+}
+
+/*
+	Reopens the corpora (and vocabularies) already in the corpora folder, so new examples get appended to them. Every corpus file
+	is checked before any of them is touched, so if one can't be reopened they're all left closed like they were.
+*/
+func (corpora *Corpora) Resume() error {
+	vocab, err := LoadVocabulary(corpora.Root)
+
+	if err != nil {
+		return err
+	}
+
+	corpora.Teams = vocab.Teams
+	corpora.Patch = vocab.Patch
+
+	if corpora.Features, err = LoadFeatureSchema(corpora.Root); err != nil {
+		return err
+	}
+
+	opened := make(map[string][][2]*os.File) // move and items files by hero and team
+	var files []*os.File
+	var sizes []int64
+
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	for hero, observed := range vocab.Heroes {
+		opened[hero] = make([][2]*os.File, len(observed))
+
+		for i := range observed {
+			for j, kind := range []string{"move", "items"} {
+				file, size, err := openClosed(corpora.CorpusPath(hero, i+2, kind))
+
+				if err != nil {
+					closeAll()
+					return err
+				}
+
+				opened[hero][i][j] = file
+				files = append(files, file)
+				sizes = append(sizes, size)
+			}
+		}
+	}
+
+	for i, file := range files {
+		if err := stripClosing(file, sizes[i]); err != nil {
+			for _, stripped := range files[:i] {
+				stripped.WriteString("\n]") // put back what stripClosing took off
+			}
+
+			closeAll()
+			return err
+		}
+	}
+
+	for hero, observed := range vocab.Heroes {
+		corpus := make([]*Corpus, len(observed))
+
+		for i := range observed {
+			corpus[i] = ResumeCorpus(opened[hero][i][0], opened[hero][i][1], observed[i])
+		}
+
+		corpora.Corpora[hero] = corpus
+	}
+
+	return nil
+}
+
+/* Names in a vocabulary ordered by ID, so generated files don't depend on map order. */
+func SortedByID(dict map[string]int) []string {
+	names := make([]string, 0, len(dict))
+
+	for name := range dict {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return dict[names[i]] < dict[names[j]] })
+	return names
+}
+
+/* Heroes in alphabetical order. */
+func (corpora *Corpora) Heroes() []string {
+	heroes := make([]string, 0, len(corpora.Corpora))
+
+	for hero := range corpora.Corpora {
+		heroes = append(heroes, hero)
+	}
+
+	sort.Strings(heroes)
+	return heroes
+}
+
+/*
+	Closes all the opened corpora files and writes the final ability/items/team composition data to vocabulary.json, the feature
+	layout to features.json and, unless vocabPath is empty, the Lua vocabularies the bots load. Every file is closed even if
+	something fails along the way.
+*/
+func (corpora *Corpora) CloseCorpora(vocabPath string) error {
+	errs := []error{corpora.WriteVocabulary(), corpora.Features.Write(corpora.Root)}
+
+	if vocabPath != "" {
+		errs = append(errs, corpora.WriteAbilityData(vocabPath))
+
+		for _, group := range corpora.Features.Groups {
+			if group.Name == "modifiers" {
+				errs = append(errs, WriteModifierData(vocabPath, group))
+			}
+		}
 	}
+
+	for _, corpus := range corpora.Corpora {
+		for _, team := range corpus {
+			errs = append(errs, team.Close())
+		}
+	}
+
+	return FirstError(errs...)
 }
 
-/* Closes all the opened corpora files and writes the final ability/items/team composition data. */
-func (corpora *Corpora) CloseCorpora() {
-	/* Write ability_data.lua */
+/* Writes ability_data.lua. */
+func (corpora *Corpora) WriteAbilityData(vocabPath string) error {
 	activeAbilities := new(bytes.Buffer)
 	activeItems := new(bytes.Buffer)
 	items := new(bytes.Buffer)
@@ -104,7 +361,7 @@ func (corpora *Corpora) CloseCorpora() {
 	items.WriteString("items = {")
 	abilities.WriteString("abilities = {")
 
-	for hero, corpus := range corpora.Corpora {
+	for _, hero := range corpora.Heroes() {
 		entry := fmt.Sprintf("%s={nil, {", hero) // map hero to team to abilities/items
 
 		activeAbilities.WriteString(entry)
@@ -112,17 +369,20 @@ func (corpora *Corpora) CloseCorpora() {
 		items.WriteString(entry)
 		abilities.WriteString(entry)
 
-		for _, team := range corpus {
+		for _, team := range corpora.Corpora[hero] {
 			/* Add an entry for the id -> ability/item as well as ability/item -> id */
-			for ability, id := range team.ObservedActiveAbilities {
+			for _, ability := range SortedByID(team.ObservedActiveAbilities) {
+				id := team.ObservedActiveAbilities[ability]
 				activeAbilities.WriteString(fmt.Sprintf("[%d]=\"%s\",%s=%d,", id, ability, ability, id))
 			}
 
-			for item, id := range team.ObservedActiveItems {
+			for _, item := range SortedByID(team.ObservedActiveItems) {
+				id := team.ObservedActiveItems[item]
 				activeItems.WriteString(fmt.Sprintf("[%d]=\"%s\",%s=%d,", id, item, item, id))
 			}
 
-			for item, id := range team.ObservedItems {
+			for _, item := range SortedByID(team.ObservedItems) {
+				id := team.ObservedItems[item]
 				items.WriteString(fmt.Sprintf("[%d]=\"%s\",%s=%d,", id, item, item, id))
 			}
 
@@ -134,8 +394,6 @@ func (corpora *Corpora) CloseCorpora() {
 			activeItems.WriteString("},{")
 			items.WriteString("},{")
 			abilities.WriteString("},{")
-
-			team.Close()
 		}
 
 		activeAbilities.WriteString("}},") // close the table for that hero
@@ -149,12 +407,9 @@ func (corpora *Corpora) CloseCorpora() {
 	items.WriteString("}\n")
 	abilities.WriteString("}\n")
 
-	if observedFile, err := os.Create("ability_data.lua"); err == nil || os.IsExist(err) {
+	if observedFile, err := os.Create(vocabPath); err == nil {
 		writer := bufio.NewWriter(observedFile)
 
-		defer observedFile.Close()
-		defer writer.Flush()
-
 		writer.WriteString("-- This is an automatically generated file. Do not modify.\n")
 		writer.WriteString("module(\"ability_data\", package.seeall)\n")
 
@@ -163,6 +418,15 @@ func (corpora *Corpora) CloseCorpora() {
 		writer.WriteString(items.String())
 		writer.WriteString(abilities.String())
 
+		/* The bot API call behind every action in move examples, for turning predicted actions into orders */
+		writer.WriteString("actions = {")
+
+		for action := 1; action <= len(ACTION_CALLS); action++ {
+			writer.WriteString(fmt.Sprintf("[%d]=\"%s\",", action, ACTION_CALLS[action]))
+		}
+
+		writer.WriteString("}\n")
+
 		/* Also write team data (which isn't per corpus which is why we're doing it down here) */
 		writer.WriteString("teams = {")
 
@@ -170,8 +434,16 @@ func (corpora *Corpora) CloseCorpora() {
 			radiant := new(bytes.Buffer)
 			dire := new(bytes.Buffer)
 
-			for hero, team_num := range team {
-				if team_num == 2 {
+			heroes := make([]string, 0, len(team))
+
+			for hero := range team {
+				heroes = append(heroes, hero)
+			}
+
+			sort.Strings(heroes)
+
+			for _, hero := range heroes {
+				if team[hero] == 2 {
 					radiant.WriteString(fmt.Sprintf("\"%s\",", hero))
 				} else {
 					dire.WriteString(fmt.Sprintf("\"%s\",", hero))
@@ -186,7 +458,22 @@ func (corpora *Corpora) CloseCorpora() {
 		}
 
 		writer.WriteString("}\n")
+
+		return FirstError(writer.Flush(), observedFile.Close())
 	} else {
-		log.Fatalf("Error creating ability_data.lua")
+		return fmt.Errorf("error creating %s: %s", vocabPath, err)
 	}
 }
+
+/* Writes vocabulary.json into the corpora folder. */
+func (corpora *Corpora) WriteVocabulary() error {
+	vocab := &Vocabulary{make(map[string][]Observed), corpora.Teams, corpora.Patch}
+
+	for hero, corpus := range corpora.Corpora {
+		for _, team := range corpus {
+			vocab.Heroes[hero] = append(vocab.Heroes[hero], team.Observed)
+		}
+	}
+
+	return vocab.Write(corpora.Root)
+}
@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResumeLeavesCorporaClosedOnError(t *testing.T) {
+	root, err := ioutil.TempDir("", "corpora")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	corpora := NewCorpora(root)
+
+	for _, hero := range []string{"npc_dota_hero_axe", "npc_dota_hero_bane", "npc_dota_hero_lina", "npc_dota_hero_zuus"} {
+		if _, err := corpora.GetCorpus(hero); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := corpora.CloseCorpora(""); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := CorpusPath(root, "npc_dota_hero_lina", 3, "items")
+
+	if err := ioutil.WriteFile(broken, []byte("[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewCorpora(root).Resume(); err == nil || !strings.Contains(err.Error(), broken) {
+		t.Fatalf("expected %s to be reported, got %v", broken, err)
+	}
+
+	for hero := range corpora.Corpora {
+		for team := 2; team <= 3; team++ {
+			for _, kind := range []string{"move", "items"} {
+				if path := CorpusPath(root, hero, team, kind); path != broken {
+					if _, err := CountExamples(path); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}
+	}
+}
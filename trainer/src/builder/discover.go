@@ -0,0 +1,210 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* Whether a file is something the builder can read demos out of. */
+func IsInput(path string) bool {
+	archive, _ := IsArchive(path)
+	return archive || IsDemoName(path)
+}
+
+/* Lists the demos and bundles under a folder and all of its subfolders, in lexical order. */
+func WalkDemos(root string) ([]string, error) {
+	var demos []string
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() && IsInput(path) {
+			demos = append(demos, path)
+		}
+
+		return nil
+	})
+
+	return demos, err
+}
+
+/*
+	Expands a glob pattern into demos and bundles. Besides filepath.Match syntax, one "**" matches any number of folders: "replays/**"
+	finds every demo under replays, and whatever follows it has to match the end of the path. Paths without pattern characters
+	are taken as they are.
+*/
+func GlobDemos(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	var matches []string
+
+	if split := strings.Index(pattern, "**"); split >= 0 {
+		prefix := filepath.Clean(pattern[:split])
+		rest := strings.TrimPrefix(pattern[split+2:], string(filepath.Separator))
+
+		if pattern[:split] == "" {
+			prefix = "."
+		}
+
+		roots, err := filepath.Glob(prefix)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, root := range roots {
+			demos, err := WalkDemos(root)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, demo := range demos {
+				if ok, err := matchSuffix(rest, demo, root); err != nil {
+					return nil, err
+				} else if ok {
+					matches = append(matches, demo)
+				}
+			}
+		}
+	} else {
+		found, err := filepath.Glob(pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range found {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && IsInput(path) {
+				matches = append(matches, path)
+			}
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no demos match %q", pattern)
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+/* Whether pattern matches the last folders of path below root (the part after a "**"). */
+func matchSuffix(pattern string, path string, root string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+
+	relative, err := filepath.Rel(root, path)
+
+	if err != nil {
+		return false, err
+	}
+
+	parts := strings.Split(relative, string(filepath.Separator))
+
+	for i := range parts {
+		if ok, err := filepath.Match(pattern, filepath.Join(parts[i:]...)); err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+/* Reads a list file (demos, patterns, accounts), one entry per line. Blank lines and lines starting with # are skipped. */
+func ReadList(list io.Reader) ([]string, error) {
+	var patterns []string
+
+	scanner := bufio.NewScanner(list)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+
+	return patterns, scanner.Err()
+}
+
+/*
+	Gathers the demos to build from: the patterns given as arguments, then every folder in dirs, then every list file in lists
+	("-" reads the list from stdin). A path found more than once is only kept the first time.
+*/
+func FindDemos(patterns []string, dirs []string, lists []string) ([]string, error) {
+	var demos []string
+
+	seen := make(map[string]bool)
+
+	add := func(paths []string) {
+		for _, path := range paths {
+			if clean := filepath.Clean(path); !seen[clean] {
+				seen[clean] = true
+				demos = append(demos, path)
+			}
+		}
+	}
+
+	glob := func(patterns []string) error {
+		for _, pattern := range patterns {
+			paths, err := GlobDemos(pattern)
+
+			if err != nil {
+				return err
+			}
+
+			add(paths)
+		}
+
+		return nil
+	}
+
+	if err := glob(patterns); err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		paths, err := WalkDemos(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		add(paths)
+	}
+
+	for _, list := range lists {
+		var patterns []string
+		var err error
+
+		if list == "-" {
+			patterns, err = ReadList(os.Stdin)
+		} else if file, openErr := os.Open(list); openErr != nil {
+			err = openErr
+		} else {
+			patterns, err = ReadList(file)
+			file.Close()
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", list, err)
+		}
+
+		if err := glob(patterns); err != nil {
+			return nil, fmt.Errorf("%s: %s", list, err)
+		}
+	}
+
+	return demos, nil
+}
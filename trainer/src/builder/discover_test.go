@@ -0,0 +1,153 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Files of the test replay folder, some of which aren't demos. */
+var TEST_TREE = []string{
+	"a.dem",
+	"notes.txt",
+	"sub/b.dem.bz2",
+	"sub/deep/c.tar.gz",
+	"sub/d.zip",
+	"sub/e.dem.txt",
+	"other/f.dem",
+}
+
+/* Creates TEST_TREE in a temp folder. */
+func writeTestTree(t *testing.T) string {
+	root, err := ioutil.TempDir("", "replays")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range TEST_TREE {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 493); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+/* Paths relative to root, with forward slashes. */
+func relativePaths(t *testing.T, root string, paths []string) string {
+	relative := make([]string, len(paths))
+
+	for i, path := range paths {
+		rel, err := filepath.Rel(root, path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		relative[i] = filepath.ToSlash(rel)
+	}
+
+	return strings.Join(relative, " ")
+}
+
+func TestWalkDemos(t *testing.T) {
+	root := writeTestTree(t)
+	defer os.RemoveAll(root)
+
+	demos, err := WalkDemos(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "a.dem other/f.dem sub/b.dem.bz2 sub/d.zip sub/deep/c.tar.gz"
+
+	if found := relativePaths(t, root, demos); found != expected {
+		t.Errorf("found %s, expected %s", found, expected)
+	}
+}
+
+func TestGlobDemos(t *testing.T) {
+	root := writeTestTree(t)
+	defer os.RemoveAll(root)
+
+	expected := map[string]string{
+		"*":            "a.dem",
+		"*/*.zip":      "sub/d.zip",
+		"**":           "a.dem other/f.dem sub/b.dem.bz2 sub/d.zip sub/deep/c.tar.gz",
+		"sub/**":       "sub/b.dem.bz2 sub/d.zip sub/deep/c.tar.gz",
+		"**/*.dem":     "a.dem other/f.dem",
+		"**/deep/*":    "sub/deep/c.tar.gz",
+		"s*/**/*.dem*": "sub/b.dem.bz2",
+	}
+
+	for pattern, paths := range expected {
+		demos, err := GlobDemos(filepath.Join(root, filepath.FromSlash(pattern)))
+
+		if err != nil {
+			t.Errorf("%s: %s", pattern, err)
+		} else if found := relativePaths(t, root, demos); found != paths {
+			t.Errorf("%s found %s, expected %s", pattern, found, paths)
+		}
+	}
+
+	if _, err := GlobDemos(filepath.Join(root, "*.tar")); err == nil {
+		t.Errorf("expected an error for a pattern matching no demos")
+	}
+
+	// Paths are taken as they are, even if they don't exist (yet)
+	if demos, err := GlobDemos("missing.dem"); err != nil || fmt.Sprint(demos) != "[missing.dem]" {
+		t.Errorf("plain path gave %v, %v", demos, err)
+	}
+}
+
+func TestReadList(t *testing.T) {
+	patterns, err := ReadList(strings.NewReader("a.dem\n\n  # a comment\n  replays/**  \n#b.dem\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(patterns) != "[a.dem replays/**]" {
+		t.Errorf("read %q", patterns)
+	}
+}
+
+func TestFindDemos(t *testing.T) {
+	root := writeTestTree(t)
+	defer os.RemoveAll(root)
+
+	list := filepath.Join(root, "demos.txt")
+	contents := "# seen already\n" + filepath.Join(root, "sub", "..", "a.dem") + "\n" + filepath.Join(root, "sub", "**") + "\n"
+
+	if err := ioutil.WriteFile(list, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	demos, err := FindDemos([]string{filepath.Join(root, "a.dem")}, []string{filepath.Join(root, "other")}, []string{list})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Patterns first, then folders, then lists, each path once
+	expected := "a.dem other/f.dem sub/b.dem.bz2 sub/d.zip sub/deep/c.tar.gz"
+
+	if found := relativePaths(t, root, demos); found != expected {
+		t.Errorf("found %s, expected %s", found, expected)
+	}
+
+	if _, err := FindDemos(nil, nil, []string{filepath.Join(root, "missing.txt")}); err == nil {
+		t.Errorf("expected an error for a missing list file")
+	}
+}
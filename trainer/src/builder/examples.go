@@ -4,21 +4,33 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"log"
 )
 
-func WriteToCorpus(example interface{}, file *bufio.Writer) {
+func WriteToCorpus(example interface{}, file *bufio.Writer) error {
 	if output, err := json.MarshalIndent(example, "", "\t"); err == nil {
-		file.WriteString(fmt.Sprintf("%s,", output))
+		_, err := file.WriteString(fmt.Sprintf("%s,", output))
+		return err
 	} else {
-		log.Fatal("Failed to serialize example")
+		return fmt.Errorf("failed to serialize example: %s", err)
 	}
 }
 
+/* Where an example came from. Not a feature, the trainer ignores it. */
+type Provenance struct {
+	AccountID uint32 `json:"account,omitempty"` // 0 for bots
+	PlayerID  int32  `json:"player"`
+	Tick      uint32 `json:"tick"`
+	Order     int32  `json:"order,omitempty"` // order type of move examples, see dota.DotaunitorderT
+}
+
 /* Represents a move/attack example. */
 type MoveExample struct {
 	MoveInputExample  `json:"input"`
 	MoveOutputExample `json:"output"`
+
+	Sequence []MoveOutputExample `json:"sequence,omitempty"` // orders queued after output, with -sequences
+
+	Provenance *Provenance `json:"provenance,omitempty"`
 }
 
 type MoveInputExample struct {
@@ -30,17 +42,32 @@ type MoveInputExample struct {
 	CurrentX   float32 `json:"6"`
 	CurrentY   float32 `json:"7"`
 
-	OtherX [9]float32 `json:"8"`
+	OtherX [9]float32 `json:"8"` // 4 allies then 5 enemies, see OtherHeroes
 	OtherY [9]float32 `json:"9"`
 
+	Extra []float32 `json:"10,omitempty"` // optional feature groups, laid out like features.json says
+
 	MoveInputLabels `json:"labels,omitempty"`
 }
 
 type MoveInputLabels struct {
 	AbilityCooldowns []float32 `json:"1"`
 	CurrentItems     []int     `json:"2"`
+
+	// one per CurrentItems entry
+	ItemCooldowns []float32 `json:"3"`
+	ItemCharges   []float32 `json:"4"`
+	ItemSlots     []int     `json:"5"` // see ItemSlotType
 }
 
+/* Item slot types, numbered like the bot API's ITEM_SLOT_TYPE_*, plus the neutral item slot. */
+const (
+	ItemSlotMain = iota
+	ItemSlotBackpack
+	ItemSlotStash
+	ItemSlotNeutral
+)
+
 /* Target types. */
 const (
 	TargetTower = iota + 1
@@ -63,14 +90,18 @@ type MoveOutputExample struct {
 
 type MoveOutputLabels struct {
 	Target      int `json:"1"`
-	AbilityUsed int `json:"2"`
-	ItemUsed    int `json:"3"`
+	AbilityUsed int `json:"2"` // 1 if none, 0 if the order's ability can't be found
+	ItemUsed    int `json:"3"` // likewise
+	Action      int `json:"4"` // the bot API call behind the order, see OrderAction
+	Queued      int `json:"5"` // 1 if shift-queued after the previous order, like ActionQueue_* instead of Action_*
 }
 
 /* Represents an item/ability build example. */
 type BuildExample struct {
 	BuildInputExample  `json:"input"`
 	BuildOutputExample `json:"output"`
+
+	Provenance *Provenance `json:"provenance,omitempty"`
 }
 
 type BuildInputExample struct {
@@ -0,0 +1,302 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+/* Where the layout of the optional move features goes, next to vocabulary.json. */
+const FEATURES_FILE = "features.json"
+
+/* Scales that bring the hero state features to roughly [0, 1]. */
+const GOLD_SCALE = 10000.0
+const NET_WORTH_SCALE = 50000.0
+const LAST_HIT_SCALE = 500.0
+const DENY_SCALE = 100.0
+const KILL_SCALE = 30.0
+const ASSIST_SCALE = 50.0
+const BUYBACK_SCALE = 480.0
+const RESPAWN_SCALE = 100.0
+
+/* What feature groups get to look at when a move example is recorded. */
+type FeatureContext struct {
+	Parser   *manta.Parser
+	Hero     *manta.Entity
+	PlayerID int32
+	Team     uint64
+	Resource *manta.Entity // CDOTA_PlayerResource, nil until it's been seen
+	TeamData *manta.Entity // the team's CDOTA_DataRadiant/CDOTA_DataDire, nil until it's been seen
+	Units    *UnitIndex    // the live units, for the nearby feature group
+	Now      Moment
+
+	Objectives *Objectives // the state of the map, for the objectives feature group
+	Modifiers  *Modifiers  // for the modifiers feature group
+}
+
+/* Reads the player's m_vecPlayerTeamData netprop of the player resource. */
+func (context *FeatureContext) player(prop string) (int32, bool) {
+	if context.Resource == nil {
+		return 0, false
+	}
+
+	return context.Resource.GetInt32(fmt.Sprintf("m_vecPlayerTeamData.%04d.%s", context.PlayerID, prop))
+}
+
+/* Reads the player's m_vecDataTeam netprop of the team data. */
+func (context *FeatureContext) team(prop string) (int32, bool) {
+	if context.TeamData == nil {
+		return 0, false
+	}
+
+	return context.TeamData.GetInt32(fmt.Sprintf("m_vecDataTeam.%04d.%s", context.PlayerID%5, prop))
+}
+
+/*
+	A group of optional features MoveExamples can carry in Extra, all mirroring something the bot API can tell the bots. Features
+	lists what each value is, with the bot API call it mirrors. Radial groups repeat their features for every radius in Radii.
+*/
+type FeatureGroup struct {
+	Name     string    `json:"name"`
+	Offset   int       `json:"offset"` // in Extra
+	Features []string  `json:"features"`
+	Radii    []float32 `json:"radii,omitempty"` // in world units, radial groups only
+
+	Modifiers []string `json:"modifiers,omitempty"` // the modifiers group's vocabulary
+	Enemies   int      `json:"enemies,omitempty"`   // how many of the nearest enemy heroes the modifiers group looks at
+
+	extract func(context *FeatureContext) []float32
+	radial  func(context *FeatureContext, radius float32) []float32 // instead of extract, Features have the radius as %[1]g
+	layout  func(group *FeatureGroup, options FeatureOptions)       // for groups whose features depend on the options
+}
+
+/* What the optional feature groups of move examples are and how they're laid out. */
+type FeatureOptions struct {
+	Groups      []string  // names of the feature groups, see FEATURE_GROUPS
+	NearbyRadii []float32 // radii (in world units) radial groups look within
+
+	Modifiers       []string // modifiers the modifiers group looks for
+	ModifierEnemies int      // how many of the nearest enemy heroes the modifiers group looks at besides the hero
+}
+
+/* Every feature group, in the order they're laid out in Extra. */
+var FEATURE_GROUPS = []FeatureGroup{
+	{Name: "gold", Features: []string{"reliable gold / 10000 (GetGold)", "unreliable gold / 10000 (GetGold)"}, extract: func(context *FeatureContext) []float32 {
+		reliable, _ := context.team("m_iReliableGold")
+		unreliable, _ := context.team("m_iUnreliableGold")
+
+		return []float32{float32(reliable) / GOLD_SCALE, float32(unreliable) / GOLD_SCALE}
+	}},
+	{Name: "networth", Features: []string{"net worth / 50000 (GetNetWorth)"}, extract: func(context *FeatureContext) []float32 {
+		netWorth, _ := context.team("m_iNetWorth")
+		return []float32{float32(netWorth) / NET_WORTH_SCALE}
+	}},
+	{Name: "farm", Features: []string{"last hits / 500 (GetLastHits)", "denies / 100 (GetDenies)"}, extract: func(context *FeatureContext) []float32 {
+		lastHits, _ := context.team("m_iLastHitCount")
+		denies, _ := context.team("m_iDenyCount")
+
+		return []float32{float32(lastHits) / LAST_HIT_SCALE, float32(denies) / DENY_SCALE}
+	}},
+	{Name: "kda", Features: []string{"kills / 30 (GetHeroKills)", "deaths / 30 (GetHeroDeaths)", "assists / 50 (GetHeroAssists)"}, extract: func(context *FeatureContext) []float32 {
+		kills, _ := context.player("m_iKills")
+		deaths, _ := context.player("m_iDeaths")
+		assists, _ := context.player("m_iAssists")
+
+		return []float32{float32(kills) / KILL_SCALE, float32(deaths) / KILL_SCALE, float32(assists) / ASSIST_SCALE}
+	}},
+	{Name: "buyback", Features: []string{"seconds until buyback is off cooldown / 480 (GetBuybackCooldown)"}, extract: func(context *FeatureContext) []float32 {
+		if context.Resource == nil || !context.Now.HasClock {
+			return []float32{0}
+		}
+
+		ready, _ := context.Resource.GetFloat32(fmt.Sprintf("m_vecPlayerTeamData.%04d.m_flBuybackCooldownTime", context.PlayerID))
+
+		if ready <= context.Now.Clock {
+			return []float32{0}
+		}
+
+		return []float32{(ready - context.Now.Clock) / BUYBACK_SCALE}
+	}},
+	{Name: "alive", Features: []string{"1 if alive, else 0 (IsAlive)", "seconds until respawn / 100 (GetRespawnTime)"}, extract: func(context *FeatureContext) []float32 {
+		lifeState, _ := context.Hero.GetInt32("m_lifeState")
+
+		if lifeState == 0 {
+			return []float32{1, 0}
+		}
+
+		respawn, _ := context.player("m_iRespawnSeconds")
+		return []float32{0, float32(respawn) / RESPAWN_SCALE}
+	}},
+	{Name: "nearby", Features: nearbyFeatures(), radial: NearbyFeatures, layout: func(group *FeatureGroup, options FeatureOptions) {
+		group.Radii = options.NearbyRadii
+		group.Features = radialFeatures(group.Features, options.NearbyRadii)
+	}},
+	{Name: "objectives", Features: objectiveFeatures(), extract: ObjectiveFeatures},
+	{Name: "modifiers", extract: ModifierFeatures, layout: func(group *FeatureGroup, options FeatureOptions) {
+		group.Modifiers = options.Modifiers
+		group.Enemies = options.ModifierEnemies
+		group.Features = modifierFeatures(options.Modifiers, options.ModifierEnemies)
+	}},
+}
+
+/* The feature groups a corpora folder's move examples carry in Extra, and where. Written to features.json. */
+type FeatureSchema struct {
+	Groups []FeatureGroup `json:"groups"`
+}
+
+/*
+	The schema for the feature groups options names. Groups are always laid out in FEATURE_GROUPS order, whatever order they're
+	named in.
+*/
+func NewFeatureSchema(options FeatureOptions) (FeatureSchema, error) {
+	wanted := make(map[string]bool)
+
+	for _, name := range options.Groups {
+		wanted[name] = true
+	}
+
+	schema := FeatureSchema{[]FeatureGroup{}}
+	offset := 0
+
+	for _, group := range FEATURE_GROUPS {
+		if wanted[group.Name] {
+			group.Offset = offset
+
+			if group.layout != nil {
+				group.layout(&group, options)
+			}
+
+			schema.Groups = append(schema.Groups, group)
+
+			offset += len(group.Features)
+			delete(wanted, group.Name)
+		}
+	}
+
+	for name := range wanted {
+		return schema, fmt.Errorf("unknown feature group %q (known: %s)", name, strings.Join(FeatureGroupNames(), ", "))
+	}
+
+	return schema, nil
+}
+
+/* Repeats a radial group's features for every radius. */
+func radialFeatures(templates []string, radii []float32) []string {
+	features := []string{}
+
+	for _, radius := range radii {
+		for _, template := range templates {
+			features = append(features, fmt.Sprintf(template, radius))
+		}
+	}
+
+	return features
+}
+
+func FeatureGroupNames() []string {
+	names := make([]string, len(FEATURE_GROUPS))
+
+	for i, group := range FEATURE_GROUPS {
+		names[i] = group.Name
+	}
+
+	return names
+}
+
+/* Number of values in Extra. */
+func (schema FeatureSchema) Size() int {
+	size := 0
+
+	for _, group := range schema.Groups {
+		size += len(group.Features)
+	}
+
+	return size
+}
+
+/* Whether two schemas lay out Extra the same way. */
+func (schema FeatureSchema) Equal(other FeatureSchema) bool {
+	return reflect.DeepEqual(schema.names(), other.names())
+}
+
+func (schema FeatureSchema) names() [][]string {
+	names := [][]string{}
+
+	for _, group := range schema.Groups {
+		names = append(names, append([]string{group.Name}, group.Features...))
+	}
+
+	return names
+}
+
+func (schema FeatureSchema) String() string {
+	if len(schema.Groups) == 0 {
+		return "no feature groups"
+	}
+
+	names := make([]string, len(schema.Groups))
+
+	for i, group := range schema.Groups {
+		names[i] = group.Name
+
+		if len(group.Radii) > 0 {
+			names[i] += fmt.Sprintf("%g", group.Radii)
+		}
+	}
+
+	return "feature groups " + strings.Join(names, ",")
+}
+
+/* Computes Extra for a move example, nil if there are no feature groups. */
+func (schema FeatureSchema) Extract(context *FeatureContext) []float32 {
+	if len(schema.Groups) == 0 {
+		return nil
+	}
+
+	extra := make([]float32, 0, schema.Size())
+
+	for _, group := range schema.Groups {
+		if group.radial == nil {
+			extra = append(extra, group.extract(context)...)
+			continue
+		}
+
+		for _, radius := range group.Radii {
+			extra = append(extra, group.radial(context, radius)...)
+		}
+	}
+
+	return extra
+}
+
+/* Reads the features.json of a corpora folder. Folders without one have no feature groups. */
+func LoadFeatureSchema(root string) (FeatureSchema, error) {
+	schema := FeatureSchema{[]FeatureGroup{}}
+	input, err := ioutil.ReadFile(filepath.Join(root, FEATURES_FILE))
+
+	if os.IsNotExist(err) {
+		return schema, nil
+	} else if err != nil {
+		return schema, err
+	}
+
+	if err := json.Unmarshal(input, &schema); err != nil {
+		return schema, fmt.Errorf("%s: %s", FEATURES_FILE, err)
+	}
+
+	return schema, nil
+}
+
+func (schema FeatureSchema) Write(root string) error {
+	if output, err := json.MarshalIndent(schema, "", "\t"); err == nil {
+		return ioutil.WriteFile(filepath.Join(root, FEATURES_FILE), output, 0644)
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,87 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewFeatureSchema(t *testing.T) {
+	// Named out of order, laid out in FEATURE_GROUPS order
+	schema, err := NewFeatureSchema(FeatureOptions{Groups: []string{"kda", "gold", "alive"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name   string
+		offset int
+	}{{"gold", 0}, {"kda", 2}, {"alive", 5}}
+
+	if len(schema.Groups) != len(expected) {
+		t.Fatalf("schema has %s, expected gold, kda and alive", schema)
+	}
+
+	for i, group := range schema.Groups {
+		if group.Name != expected[i].name || group.Offset != expected[i].offset {
+			t.Errorf("group %d is %s at %d, expected %s at %d", i, group.Name, group.Offset, expected[i].name, expected[i].offset)
+		}
+	}
+
+	if schema.Size() != 7 {
+		t.Errorf("schema has %d values, expected 7", schema.Size())
+	}
+
+	if _, err := NewFeatureSchema(FeatureOptions{Groups: []string{"gold", "mana"}}); err == nil {
+		t.Errorf("expected an error for an unknown feature group")
+	}
+
+	if none, err := NewFeatureSchema(FeatureOptions{}); err != nil || none.Size() != 0 || none.Extract(nil) != nil {
+		t.Errorf("schema without groups has %d values (%v)", none.Size(), err)
+	}
+}
+
+func TestFeatureSchemaRoundTrip(t *testing.T) {
+	root, err := ioutil.TempDir("", "corpora")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	if schema, err := LoadFeatureSchema(root); err != nil || len(schema.Groups) != 0 {
+		t.Errorf("folder without %s has %s (%v)", FEATURES_FILE, schema, err)
+	}
+
+	schema, err := NewFeatureSchema(FeatureOptions{Groups: []string{"gold", "networth", "buyback"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schema.Write(root); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFeatureSchema(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.Equal(schema) || loaded.Size() != 4 || loaded.Groups[2].Offset != 3 {
+		t.Errorf("loaded %s with %d values, expected %s with 4", loaded, loaded.Size(), schema)
+	}
+
+	other, err := NewFeatureSchema(FeatureOptions{Groups: []string{"gold", "networth"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Equal(other) {
+		t.Errorf("%s and %s lay Extra out the same way", loaded, other)
+	}
+}
@@ -0,0 +1,180 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* Names for the common DOTA_GameMode values. */
+var GAME_MODES = map[string]int32{
+	"all_pick":       1,
+	"captains_mode":  2,
+	"random_draft":   3,
+	"single_draft":   4,
+	"all_random":     5,
+	"least_played":   12,
+	"custom":         15,
+	"captains_draft": 16,
+	"ability_draft":  18,
+	"ardm":           20,
+	"1v1_mid":        21,
+	"all_draft":      22, // ranked all pick
+	"turbo":          23,
+	"mutation":       24,
+}
+
+/* Names for the common lobby types. */
+var LOBBY_TYPES = map[string]int32{
+	"public":     0,
+	"practice":   1,
+	"tournament": 2,
+	"coop_bots":  4,
+	"ranked":     7,
+	"1v1_mid":    8,
+	"battle_cup": 9,
+}
+
+/* Parses a comma separated list of names from names, or plain numbers. */
+func ParseIDs(list string, names map[string]int32) (map[int32]bool, error) {
+	ids := make(map[int32]bool)
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+
+		if id, ok := names[field]; ok {
+			ids[id] = true
+		} else if id, err := strconv.ParseInt(field, 10, 32); err == nil {
+			ids[int32(id)] = true
+		} else {
+			return nil, fmt.Errorf("unknown %q", field)
+		}
+	}
+
+	return ids, nil
+}
+
+/* Splits a patch name like 7.33c into numbers and letters: 7, 33, "c". */
+func patchParts(patch string) []interface{} {
+	var parts []interface{}
+
+	for _, field := range strings.Split(patch, ".") {
+		digits := strings.IndexFunc(field, func(r rune) bool { return !unicode.IsDigit(r) })
+
+		if digits < 0 {
+			digits = len(field)
+		}
+
+		number, _ := strconv.Atoi(field[:digits])
+		parts = append(parts, number)
+
+		if digits < len(field) {
+			parts = append(parts, field[digits:])
+		}
+	}
+
+	return parts
+}
+
+/* Orders patch names: 7.9 < 7.10 < 7.33 < 7.33a < 7.33c < 7.34. Returns -1, 0 or 1. */
+func ComparePatches(a string, b string) int {
+	x, y := patchParts(a), patchParts(b)
+
+	for i := 0; i < len(x) && i < len(y); i++ {
+		switch p := x[i].(type) {
+		case int:
+			q, ok := y[i].(int)
+
+			if !ok { // number after a letter only happens in odd names, letters go last
+				return -1
+			} else if p != q {
+				if p < q {
+					return -1
+				}
+
+				return 1
+			}
+		case string:
+			q, ok := y[i].(string)
+
+			if !ok {
+				return 1
+			} else if p != q {
+				if p < q {
+					return -1
+				}
+
+				return 1
+			}
+		}
+	}
+
+	if len(x) < len(y) {
+		return -1
+	} else if len(x) > len(y) {
+		return 1
+	}
+
+	return 0
+}
+
+/* Which matches go into the corpora. Empty sets and patch bounds let everything through. */
+type MatchFilter struct {
+	Modes      map[int32]bool
+	LobbyTypes map[int32]bool
+	MinPatch   string
+	MaxPatch   string // 7.33 includes 7.33a and so on
+}
+
+/* Whether the filter only lets some patches through. */
+func (filter *MatchFilter) ByPatch() bool {
+	return filter.MinPatch != "" || filter.MaxPatch != ""
+}
+
+/* Checks only the game mode, which the demo's file info already has before it's parsed. */
+func (filter *MatchFilter) CheckMode(gameMode int32) error {
+	if len(filter.Modes) > 0 && !filter.Modes[gameMode] {
+		return filtered(fmt.Sprintf("game mode %d is filtered out", gameMode))
+	}
+
+	return nil
+}
+
+/* Returns a Filtered *SkipError if the match doesn't pass the filter. */
+func (filter *MatchFilter) Check(gameMode int32, lobbyType int32, build uint32, patch string) error {
+	if err := filter.CheckMode(gameMode); err != nil {
+		return err
+	}
+
+	if len(filter.LobbyTypes) > 0 && !filter.LobbyTypes[lobbyType] {
+		return filtered(fmt.Sprintf("lobby type %d is filtered out", lobbyType))
+	}
+
+	if filter.ByPatch() && patch == "" {
+		return filtered(fmt.Sprintf("patch of build %d is unknown", build))
+	}
+
+	if filter.MinPatch != "" && ComparePatches(patch, filter.MinPatch) < 0 {
+		return filtered(fmt.Sprintf("patch %s is before %s", patch, filter.MinPatch))
+	}
+
+	if filter.MaxPatch != "" && ComparePatches(patch, filter.MaxPatch) > 0 && !isLetterPatchOf(patch, filter.MaxPatch) {
+		return filtered(fmt.Sprintf("patch %s is after %s", patch, filter.MaxPatch))
+	}
+
+	return nil
+}
+
+/* Whether patch is one of the lettered patches of base, like 7.33c of 7.33. */
+func isLetterPatchOf(patch string, base string) bool {
+	if !strings.HasPrefix(patch, base) || len(patch) == len(base) {
+		return false
+	}
+
+	return unicode.IsLetter(rune(patch[len(base)]))
+}
+
+func filtered(reason string) error {
+	return &SkipError{Reason: reason, Filtered: true}
+}
@@ -0,0 +1,41 @@
+package builder
+
+import (
+	"testing"
+)
+
+func TestParseIDs(t *testing.T) {
+	ids, err := ParseIDs(" All_Pick, 23 ,all_draft", GAME_MODES)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 3 || !ids[1] || !ids[22] || !ids[23] {
+		t.Errorf("parsed %v", ids)
+	}
+
+	if _, err := ParseIDs("all_pick,ranked", GAME_MODES); err == nil {
+		t.Error("expected an unknown name to be an error")
+	}
+}
+
+func TestComparePatches(t *testing.T) {
+	ordered := []string{"6.88", "7.9", "7.10", "7.33", "7.33a", "7.33c", "7.34"}
+
+	for i, a := range ordered {
+		for j, b := range ordered {
+			expected := 0
+
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+
+			if compared := ComparePatches(a, b); compared != expected {
+				t.Errorf("ComparePatches(%s, %s) = %d, expected %d", a, b, compared, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/dotabuff/manta/dota"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+	A demo that can be opened, and opened again for the second pass, as a plain .dem stream.
+
+	Demos can be compressed (.dem.bz2 as served by Valve's CDN, .dem.gz, .dem.zst) and can live inside .tar (optionally
+	compressed) or .zip bundles, in which case Member is their name in the archive at Path. Tar members are read straight from
+	where they are in the (uncompressed) tar at Path, which ExpandDemos works out once.
+*/
+type DemoSource struct {
+	Name   string // what the demo is called in logs and the manifest
+	Path   string
+	Member string
+	Offset int64 // of a tar member's contents
+	Size   int64 // of a tar member's contents
+}
+
+/* Separates the archive and member parts of a DemoSource's name. */
+const ARCHIVE_SEPARATOR = "!"
+
+/* Compression formats, by extension. */
+var compressions = []string{".bz2", ".gz", ".zst"}
+
+/* Strips a compression extension off a file name. */
+func stripCompression(name string) (string, string) {
+	for _, ext := range compressions {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), ext
+		}
+	}
+
+	return name, ""
+}
+
+/* Whether name looks like a (possibly compressed) demo. */
+func IsDemoName(name string) bool {
+	name, _ = stripCompression(name)
+	return strings.HasSuffix(name, ".dem")
+}
+
+/* Whether path is a demo bundle, and if so whether it's a zip (as opposed to a tar). */
+func IsArchive(path string) (archive bool, isZip bool) {
+	if strings.HasSuffix(path, ".zip") {
+		return true, true
+	}
+
+	path, _ = stripCompression(path)
+	return strings.HasSuffix(path, ".tar") || strings.HasSuffix(path, ".tgz") || strings.HasSuffix(path, ".tbz2"), false
+}
+
+/* Reader that closes a stack of underlying readers. */
+type stackedReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (reader *stackedReader) Close() error {
+	var errs []error
+
+	for i := len(reader.closers) - 1; i >= 0; i-- {
+		errs = append(errs, reader.closers[i].Close())
+	}
+
+	return FirstError(errs...)
+}
+
+/* The compression extension of a file name, "" if it isn't compressed. */
+func compressionOf(name string) string {
+	if strings.HasSuffix(name, ".tgz") {
+		return ".gz"
+	} else if strings.HasSuffix(name, ".tbz2") {
+		return ".bz2"
+	}
+
+	_, ext := stripCompression(name)
+	return ext
+}
+
+/* A member of an uncompressed tar, read straight out of it. Like a plain demo file it can seek. */
+type tarMember struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (member *tarMember) Close() error {
+	return member.file.Close()
+}
+
+/* Wraps a stream in the decompressor its name calls for. */
+func decompress(stream io.ReadCloser, name string) (io.ReadCloser, error) {
+	switch compressionOf(name) {
+	case ".bz2":
+		return &stackedReader{bzip2.NewReader(stream), []io.Closer{stream}}, nil
+	case ".gz":
+		reader, err := gzip.NewReader(stream)
+
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+
+		return &stackedReader{reader, []io.Closer{stream, reader}}, nil
+	case ".zst":
+		reader, err := zstd.NewReader(stream)
+
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+
+		decoder := reader.IOReadCloser()
+		return &stackedReader{decoder, []io.Closer{stream, decoder}}, nil
+	}
+
+	return stream, nil
+}
+
+/*
+	Returns the path of an uncompressed copy of a tar bundle: the bundle itself if it isn't compressed, otherwise a copy
+	decompressed into scratch, so its members can be read without decompressing everything before them every time.
+*/
+func uncompressedTar(path string, scratch string) (string, error) {
+	if compressionOf(path) == "" {
+		return path, nil
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	stream, err := decompress(file, path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer stream.Close()
+
+	copied, err := ioutil.TempFile(scratch, "bundle")
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(copied, stream)
+
+	if err := FirstError(err, copied.Close()); err != nil {
+		os.Remove(copied.Name())
+		return "", err
+	}
+
+	return copied.Name(), nil
+}
+
+/* Opens the demo as a decompressed stream. */
+func (source DemoSource) Open() (io.ReadCloser, error) {
+	if source.Member == "" {
+		file, err := os.Open(source.Path)
+
+		if err != nil {
+			return nil, fmt.Errorf("can't open demo: %s", err)
+		}
+
+		return decompress(file, source.Path)
+	}
+
+	if _, isZip := IsArchive(source.Path); isZip {
+		archive, err := zip.OpenReader(source.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range archive.File {
+			if file.Name == source.Member {
+				member, err := file.Open()
+
+				if err != nil {
+					archive.Close()
+					return nil, err
+				}
+
+				return decompress(&stackedReader{member, []io.Closer{archive, member}}, source.Member)
+			}
+		}
+
+		archive.Close()
+	} else {
+		file, err := os.Open(source.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return decompress(&tarMember{io.NewSectionReader(file, source.Offset, source.Size), file}, source.Member)
+	}
+
+	return nil, fmt.Errorf("%s isn't in %s", source.Member, source.Path)
+}
+
+/* Fingerprints the demo's (decompressed) contents. */
+func (source DemoSource) Fingerprint() (Fingerprint, error) {
+	demo, err := source.Open()
+
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	defer demo.Close()
+
+	return FingerprintDemo(demo)
+}
+
+/* Fingerprints the demo by its file info alone (see PeekDemo). */
+func (source DemoSource) Peek() (Fingerprint, error) {
+	demo, err := source.Open()
+
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	defer demo.Close()
+
+	return PeekDemo(demo), nil
+}
+
+/* Magic at the start of every Source 2 demo. */
+const DEMO_STAMP = "PBDEMS2\x00"
+
+/*
+	Reads the CDemoFileInfo (match ID, game mode, players...) Source 2 demos keep near their end. The header says where it is, so
+	this seeks to it if the demo can seek, and otherwise reads through the stream up to it without parsing anything else.
+*/
+func ReadFileInfo(demo io.Reader) (*dota.CDemoFileInfo, error) {
+	reader := bufio.NewReader(demo)
+	header := make([]byte, 16) // stamp, file info offset, spawn groups offset
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if string(header[:8]) != DEMO_STAMP {
+		return nil, errors.New("not a Source 2 demo")
+	}
+
+	offset := int64(binary.LittleEndian.Uint32(header[8:12]))
+
+	if offset < 16 {
+		return nil, errors.New("demo has no file info")
+	}
+
+	if seeker, ok := demo.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		reader.Reset(demo)
+	} else if _, err := io.CopyN(ioutil.Discard, reader, offset-16); err != nil {
+		return nil, err
+	}
+
+	command, err := binary.ReadUvarint(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := binary.ReadUvarint(reader); err != nil { // tick
+		return nil, err
+	}
+
+	size, err := binary.ReadUvarint(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if size > 1<<20 {
+		return nil, errors.New("file info is implausibly large")
+	}
+
+	message := make([]byte, size)
+
+	if _, err := io.ReadFull(reader, message); err != nil {
+		return nil, err
+	}
+
+	if command&uint64(dota.EDemoCommands_DEM_IsCompressed) != 0 {
+		if message, err = snappy.Decode(nil, message); err != nil {
+			return nil, err
+		}
+	}
+
+	if dota.EDemoCommands(command&^uint64(dota.EDemoCommands_DEM_IsCompressed)) != dota.EDemoCommands_DEM_FileInfo {
+		return nil, fmt.Errorf("expected file info, found demo command %d", command)
+	}
+
+	info := &dota.CDemoFileInfo{}
+
+	if err := proto.Unmarshal(message, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+/* Lists the demos in a bundle, in archive order. Compressed tars are decompressed into scratch first (see uncompressedTar). */
+func archiveMembers(path string, scratch string) ([]DemoSource, error) {
+	var demos []DemoSource
+
+	if _, isZip := IsArchive(path); isZip {
+		archive, err := zip.OpenReader(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer archive.Close()
+
+		for _, file := range archive.File {
+			if !file.FileInfo().IsDir() && IsDemoName(file.Name) {
+				demos = append(demos, DemoSource{Name: path + ARCHIVE_SEPARATOR + file.Name, Path: path, Member: file.Name})
+			}
+		}
+
+		return demos, nil
+	}
+
+	uncompressed, err := uncompressedTar(path, scratch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(uncompressed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	archive := tar.NewReader(file)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			return demos, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg || !IsDemoName(header.Name) {
+			continue
+		}
+
+		// The tar reader doesn't read ahead, so the file is right where the member's contents start
+		offset, err := file.Seek(0, io.SeekCurrent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		demos = append(demos, DemoSource{path + ARCHIVE_SEPARATOR + header.Name, uncompressed, header.Name, offset, header.Size})
+	}
+}
+
+/*
+	Turns demo and bundle paths into the demos to parse. Bundles are only gone through once, here: compressed tars are
+	decompressed into scratch, which has to stay around for as long as the demos are opened.
+*/
+func ExpandDemos(paths []string, scratch string) ([]DemoSource, error) {
+	var demos []DemoSource
+
+	for _, path := range paths {
+		if archive, _ := IsArchive(path); archive {
+			members, err := archiveMembers(path, scratch)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+
+			demos = append(demos, members...)
+		} else {
+			demos = append(demos, DemoSource{Name: path, Path: path})
+		}
+	}
+
+	return demos, nil
+}
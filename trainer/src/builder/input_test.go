@@ -0,0 +1,276 @@
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/* Members of the test bundles and what's in them (compressed, if their name says so), with something that isn't a demo in between. */
+var TEST_MEMBERS = [][2]string{
+	{"a.dem", "first demo"},
+	{"readme.txt", "not a demo"},
+	{"replays/b.dem.gz", "second demo"},
+	{"c.dem", "third demo, a bit longer than the others so it spans more than one tar block" + string(make([]byte, 600))},
+	{"d.dem.zst", "fourth demo"},
+}
+
+/* Wraps writer in the compressor for a compression extension. */
+func compressTo(t *testing.T, writer io.Writer, ext string) io.WriteCloser {
+	switch ext {
+	case ".gz":
+		return gzip.NewWriter(writer)
+	case ".zst":
+		encoder, err := zstd.NewWriter(writer)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return encoder
+	}
+
+	t.Fatalf("can't write %s files", ext)
+	return nil
+}
+
+/* Contents of a test member as stored in the bundle. */
+func testMember(t *testing.T, name string, contents string) []byte {
+	ext := compressionOf(name)
+
+	if ext == "" {
+		return []byte(contents)
+	}
+
+	stored := new(bytes.Buffer)
+	writer := compressTo(t, stored, ext)
+	writer.Write([]byte(contents))
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return stored.Bytes()
+}
+
+/* Reads a demo whole, the way the parser would see it. */
+func readTestDemo(t *testing.T, demo DemoSource) string {
+	stream, err := demo.Open()
+
+	if err != nil {
+		t.Fatalf("%s: %s", demo.Name, err)
+	}
+
+	contents, err := ioutil.ReadAll(stream)
+	stream.Close()
+
+	if err != nil {
+		t.Fatalf("%s: %s", demo.Name, err)
+	}
+
+	return string(contents)
+}
+
+func writeTestTar(t *testing.T, writer io.Writer, members [][2]string) {
+	archive := tar.NewWriter(writer)
+
+	for _, member := range members {
+		contents := testMember(t, member[0], member[1])
+
+		if err := archive.WriteHeader(&tar.Header{Name: member[0], Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := archive.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, writer io.Writer, members [][2]string) {
+	archive := zip.NewWriter(writer)
+
+	for _, member := range members {
+		file, err := archive.Create(member[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write(testMember(t, member[0], member[1]))
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* Writes a bundle of members called name into dir. */
+func writeTestBundle(t *testing.T, dir string, name string, members [][2]string) string {
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if _, isZip := IsArchive(name); isZip {
+		writeTestZip(t, file, members)
+	} else if ext := compressionOf(name); ext != "" {
+		compressed := compressTo(t, file, ext)
+		writeTestTar(t, compressed, members)
+
+		if err := compressed.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		writeTestTar(t, file, members)
+	}
+
+	return path
+}
+
+func TestExpandDemosOpensMembers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundles")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var expected []string
+
+	for _, member := range TEST_MEMBERS {
+		if IsDemoName(member[0]) {
+			expected = append(expected, member[1])
+		}
+	}
+
+	for _, name := range []string{"bundle.tar", "bundle.tar.gz", "bundle.tar.zst", "bundle.zip"} {
+		path := writeTestBundle(t, dir, name, TEST_MEMBERS)
+		demos, err := ExpandDemos([]string{path}, dir)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if len(demos) != len(expected) {
+			t.Fatalf("%s: expected %d demos, got %d", name, len(expected), len(demos))
+		}
+
+		// Backwards, so no member can be read by carrying on from the one before
+		for i := len(demos) - 1; i >= 0; i-- {
+			demo := demos[i]
+
+			if demo.Name != path+ARCHIVE_SEPARATOR+demo.Member {
+				t.Errorf("%s: demo called %s", name, demo.Name)
+			}
+
+			if contents := readTestDemo(t, demo); contents != expected[i] {
+				t.Errorf("%s: read %q, expected %q", demo.Name, contents, expected[i])
+			}
+		}
+	}
+}
+
+func TestExpandDemosOpensCompressedDemos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "demos")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, member := range TEST_MEMBERS {
+		if !IsDemoName(member[0]) {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.Base(member[0]))
+
+		if err := ioutil.WriteFile(path, testMember(t, member[0], member[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		demos, err := ExpandDemos([]string{path}, dir)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(demos) != 1 || demos[0].Member != "" {
+			t.Fatalf("%s: expanded into %+v", path, demos)
+		}
+
+		if contents := readTestDemo(t, demos[0]); contents != member[1] {
+			t.Errorf("%s: read %q, expected %q", path, contents, member[1])
+		}
+	}
+}
+
+func TestFingerprintIgnoresHowDemosAreStored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "demos")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// The same demo plain, compressed and in bundles
+	contents := string(testDemo(5000, 1))
+	members := [][2]string{{"demo.dem", contents}, {"demo.dem.gz", contents}, {"demo.dem.zst", contents}}
+
+	var demos []DemoSource
+
+	for _, member := range members {
+		path := filepath.Join(dir, member[0])
+
+		if err := ioutil.WriteFile(path, testMember(t, member[0], member[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		demos = append(demos, DemoSource{Name: path, Path: path})
+	}
+
+	for _, name := range []string{"bundle.tar.gz", "bundle.zip"} {
+		bundled, err := ExpandDemos([]string{writeTestBundle(t, dir, name, members)}, dir)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		demos = append(demos, bundled...)
+	}
+
+	expected, err := FingerprintDemo(strings.NewReader(contents))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, demo := range demos {
+		if fingerprint, err := demo.Fingerprint(); err != nil {
+			t.Errorf("%s: %s", demo.Name, err)
+		} else if fingerprint.Hash != expected.Hash {
+			t.Errorf("%s: hash %s, expected %s", demo.Name, fingerprint.Hash, expected.Hash)
+		}
+	}
+}
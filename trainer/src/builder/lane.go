@@ -0,0 +1,244 @@
+package builder
+
+import (
+	"math"
+
+	"github.com/dotabuff/manta"
+)
+
+/* Lanes, numbered like the bot API's LANE_* constants. */
+const LANE_NONE = 0
+const LANE_TOP = 1
+const LANE_MID = 2
+const LANE_BOT = 3
+
+/* Waypoints along the middle of each lane in world coordinates, from the Radiant tier 3 tower to the Dire one. */
+var LANE_PATHS = [4][][2]float32{
+	LANE_TOP: {{-6600, -3700}, {-6250, 5900}, {3550, 5900}},
+	LANE_MID: {{-4600, -4100}, {4250, 3750}},
+	LANE_BOT: {{-3950, -6150}, {6200, -6250}, {6300, 3000}},
+}
+
+/* How far from the middle of a lane (in world units) a unit still counts as being in it. */
+const LANE_WIDTH = 1200.0
+
+/* The part of the game (in ticks after the horn) where heroes' positions give away which lane they were assigned. */
+const LANING_START = 60 * TICKRATE
+const LANING_END = 10 * 60 * TICKRATE
+
+/* How often heroes' positions are sampled during the laning phase, in ticks. */
+const LANE_SAMPLE_PERIOD = 5 * TICKRATE
+
+/*
+	Where a point (in remapped coordinates, see GetLocation) is along a lane, from 0 at the Radiant end to 1 at the Dire end, and
+	how far it is from the lane's path (remapped too).
+*/
+func LaneProgress(lane int, x float32, y float32) (float32, float32) {
+	path := LANE_PATHS[lane]
+
+	var length float64
+
+	for i := 1; i < len(path); i++ {
+		length += math.Hypot(float64(path[i][0]-path[i-1][0]), float64(path[i][1]-path[i-1][1]))
+	}
+
+	best, progress, along := math.Inf(1), 0.0, 0.0
+
+	for i := 1; i < len(path); i++ {
+		ax, ay := float64(RemapX(path[i-1][0])), float64(RemapY(path[i-1][1]))
+		bx, by := float64(RemapX(path[i][0])), float64(RemapY(path[i][1]))
+		dx, dy := bx-ax, by-ay
+
+		// project the point onto the segment
+		t := ((float64(x)-ax)*dx + (float64(y)-ay)*dy) / (dx*dx + dy*dy)
+		t = math.Max(0, math.Min(1, t))
+
+		segment := math.Hypot(float64(path[i][0]-path[i-1][0]), float64(path[i][1]-path[i-1][1]))
+
+		if distance := math.Hypot(float64(x)-(ax+t*dx), float64(y)-(ay+t*dy)); distance < best {
+			best = distance
+			progress = (along + t*segment) / length
+		}
+
+		along += segment
+	}
+
+	return float32(progress), float32(best)
+}
+
+/* The lane a point (in remapped coordinates) is in, LANE_NONE if it isn't near any. */
+func NearestLane(x float32, y float32) int {
+	lane, best := LANE_NONE, float32(LANE_WIDTH/(MAX_X-MIN_X))
+
+	for i := LANE_TOP; i <= LANE_BOT; i++ {
+		if _, distance := LaneProgress(i, x, y); distance < best {
+			lane, best = i, distance
+		}
+	}
+
+	return lane
+}
+
+/* An alive lane creep. */
+type laneCreep struct {
+	team     uint64
+	lane     int
+	progress float32 // from the Radiant end
+}
+
+/*
+	Tracks the lane creeps of both teams to work out the lane fronts the bots get from GetLaneFrontAmount(team, lane, true): how
+	far along a lane, from 0 at the team's own end to 1 at the enemy's, the team's creep wave has pushed. That's the team's
+	furthest creep in the lane or, while it has none there, the enemy's furthest. Towers are ignored, like the bots ask for.
+*/
+type LaneFronts struct {
+	creeps map[int32]laneCreep // by entindex
+	fronts [2][4]float32       // last known front by team (Radiant, Dire) and lane, for when a lane has no creeps at all
+	tick   uint32              // of the last update
+}
+
+func NewLaneFronts() *LaneFronts {
+	return &LaneFronts{creeps: make(map[int32]laneCreep)}
+}
+
+/*
+	Keeps track of a lane creep (CDOTA_BaseNPC_Creep_Lane) being created, moved, killed or deleted at tick. The first update of a
+	tick remembers the fronts the last one left, so lanes that lose all their creeps keep them.
+*/
+func (fronts *LaneFronts) Update(ent *manta.Entity, op manta.EntityOp, tick uint32) {
+	if tick != fronts.tick {
+		fronts.remember()
+		fronts.tick = tick
+	}
+
+	lifeState, _ := ent.GetInt32("m_lifeState")
+
+	if op.Flag(manta.EntityOpDeleted) || lifeState != 0 {
+		delete(fronts.creeps, ent.GetIndex())
+		return
+	}
+
+	team, _ := ent.GetUint64("m_iTeamNum")
+	coords := GetLocation(ent)
+
+	if lane := NearestLane(coords[0], coords[1]); lane != LANE_NONE && (team == 2 || team == 3) {
+		progress, _ := LaneProgress(lane, coords[0], coords[1])
+		fronts.creeps[ent.GetIndex()] = laneCreep{team, lane, progress}
+	} else {
+		delete(fronts.creeps, ent.GetIndex())
+	}
+}
+
+/* Remembers the front of every team in every lane that has creeps. */
+func (fronts *LaneFronts) remember() {
+	for team := uint64(2); team <= 3; team++ {
+		for lane := LANE_TOP; lane <= LANE_BOT; lane++ {
+			if front, ok := fronts.live(team, lane); ok {
+				fronts.fronts[team-2][lane] = front
+			}
+		}
+	}
+}
+
+/* The lane front of a team in a lane going by the creeps in it, false if there are none. */
+func (fronts *LaneFronts) live(team uint64, lane int) (float32, bool) {
+	own, enemy := float32(-1), float32(2)
+
+	for _, creep := range fronts.creeps {
+		if creep.lane != lane {
+			continue
+		}
+
+		progress := creep.progress
+
+		if team == 3 { // measured from the Dire end
+			progress = 1 - progress
+		}
+
+		if creep.team == team && progress > own {
+			own = progress
+		} else if creep.team != team && progress < enemy {
+			enemy = progress
+		}
+	}
+
+	if own >= 0 {
+		return own, true
+	} else if enemy <= 1 {
+		return enemy, true
+	}
+
+	return 0, false
+}
+
+/* The lane front of a team in a lane right now, 0 for LANE_NONE. */
+func (fronts *LaneFronts) Amount(team uint64, lane int) float32 {
+	if lane == LANE_NONE || (team != 2 && team != 3) {
+		return 0
+	}
+
+	if front, ok := fronts.live(team, lane); ok {
+		return front
+	}
+
+	return fronts.fronts[team-2][lane]
+}
+
+/* Every team's lane front right now, by team (Radiant, Dire) and lane. */
+func (fronts *LaneFronts) Snapshot() [2][4]float32 {
+	var snapshot [2][4]float32
+
+	for team := uint64(2); team <= 3; team++ {
+		for lane := LANE_TOP; lane <= LANE_BOT; lane++ {
+			snapshot[team-2][lane] = fronts.Amount(team, lane)
+		}
+	}
+
+	return snapshot
+}
+
+/*
+	Guesses the lane each player was assigned (what GetAssignedLane() tells the bots) from where their hero spent the laning
+	phase: the lane it was sampled in most often. Players never seen in a lane (junglers, roamers) get mid.
+*/
+type LaneAssigner struct {
+	counts  map[int32]*[4]int // samples by player ID and lane
+	sampled map[int32]uint32  // tick of each player's last sample
+}
+
+func NewLaneAssigner() *LaneAssigner {
+	return &LaneAssigner{make(map[int32]*[4]int), make(map[int32]uint32)}
+}
+
+/* Samples a hero's position if it's the laning phase of a game that started at startTime. Returns the player's lane so far. */
+func (assigner *LaneAssigner) Sample(tick uint32, startTime uint32, playerID int32, ent *manta.Entity) int {
+	if startTime != 0 && tick >= startTime+LANING_START && tick <= startTime+LANING_END {
+		if last, ok := assigner.sampled[playerID]; !ok || tick-last >= LANE_SAMPLE_PERIOD {
+			assigner.sampled[playerID] = tick
+
+			if assigner.counts[playerID] == nil {
+				assigner.counts[playerID] = &[4]int{}
+			}
+
+			coords := GetLocation(ent)
+			assigner.counts[playerID][NearestLane(coords[0], coords[1])]++
+		}
+	}
+
+	return assigner.Lane(playerID)
+}
+
+/* The lane a player has been seen in most so far. */
+func (assigner *LaneAssigner) Lane(playerID int32) int {
+	lane, best := LANE_MID, 0
+
+	if counts := assigner.counts[playerID]; counts != nil {
+		for i := LANE_TOP; i <= LANE_BOT; i++ {
+			if counts[i] > best {
+				lane, best = i, counts[i]
+			}
+		}
+	}
+
+	return lane
+}
@@ -0,0 +1,115 @@
+package builder
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLaneFrontsAmountIsReadOnly(t *testing.T) {
+	fronts := NewLaneFronts()
+	fronts.creeps[1] = laneCreep{2, LANE_MID, 0.25}
+	fronts.creeps[2] = laneCreep{2, LANE_MID, 0.5}
+	fronts.creeps[3] = laneCreep{3, LANE_MID, 0.75}
+
+	if front := fronts.Amount(2, LANE_MID); front != 0.5 {
+		t.Errorf("Radiant mid front %g, expected its furthest creep's 0.5", front)
+	}
+
+	if front := fronts.Amount(3, LANE_MID); front != 0.25 {
+		t.Errorf("Dire mid front %g, expected its furthest creep's 0.25", front)
+	}
+
+	if front := fronts.Amount(3, LANE_TOP); front != 0 {
+		t.Errorf("Dire top front %g without any creeps, expected 0", front)
+	}
+
+	if fronts.fronts != [2][4]float32{} {
+		t.Errorf("Amount changed the remembered fronts: %v", fronts.fronts)
+	}
+
+	fronts.remember()
+	delete(fronts.creeps, 2)
+	delete(fronts.creeps, 3)
+
+	if front := fronts.Amount(3, LANE_MID); front != 0.75 {
+		t.Errorf("Dire mid front %g once its creeps are gone, expected the Radiant creep's 0.75", front)
+	}
+
+	delete(fronts.creeps, 1)
+
+	if front := fronts.Amount(2, LANE_MID); front != 0.5 {
+		t.Errorf("Radiant mid front %g without any creeps, expected the remembered 0.5", front)
+	}
+}
+
+/* LaneProgress of a point given in world units, its distance back in world units too. */
+func laneProgressAt(lane int, x float32, y float32) (float32, float32) {
+	progress, distance := LaneProgress(lane, RemapX(x), RemapY(y))
+	return progress, distance * (MAX_X - MIN_X)
+}
+
+func TestLaneProgress(t *testing.T) {
+	expected := []struct {
+		lane     int
+		x, y     float32
+		progress float32
+		distance float32
+	}{
+		{LANE_MID, -4600, -4100, 0, 0},           // Radiant tier 3
+		{LANE_MID, 4250, 3750, 1, 0},             // Dire tier 3
+		{LANE_MID, -175, -175, 0.5, 0},           // halfway
+		{LANE_MID, -6000, -5000, 0, 1664.3317},   // behind the Radiant end, as far as its tier 3
+		{LANE_TOP, -6250, 5900, 0.49522, 0},      // the corner, a bit short of halfway
+		{LANE_TOP, -5000, 5500, 0.55943, 400},    // below the top stretch, past the corner
+		{LANE_BOT, 6300, 3000, 1, 0},             // Dire tier 3
+		{LANE_BOT, 1125, -6200, 0.26123, 0},      // halfway along the first stretch
+		{LANE_BOT, 1125, -5200, 0.26123, 1000.0}, // up from it
+	}
+
+	for _, point := range expected {
+		progress, distance := laneProgressAt(point.lane, point.x, point.y)
+
+		if math.Abs(float64(progress-point.progress)) > 1e-3 || math.Abs(float64(distance-point.distance)) > 1 {
+			t.Errorf("(%g, %g) is %g along lane %d and %g away, expected %g and %g", point.x, point.y, progress, point.lane, distance, point.progress, point.distance)
+		}
+	}
+}
+
+func TestNearestLane(t *testing.T) {
+	expected := map[[2]float32]int{
+		{-6400, 0}:     LANE_TOP,
+		{0, 5900}:      LANE_TOP,
+		{0, 0}:         LANE_MID,
+		{3000, 2500}:   LANE_MID,
+		{0, -6200}:     LANE_BOT,
+		{6300, 0}:      LANE_BOT,
+		{-3000, 2500}:  LANE_NONE, // Radiant jungle
+		{3000, -2500}:  LANE_NONE, // Dire jungle
+		{-7000, -7000}: LANE_NONE, // Radiant fountain
+	}
+
+	for point, lane := range expected {
+		if found := NearestLane(RemapX(point[0]), RemapY(point[1])); found != lane {
+			t.Errorf("(%g, %g) is in lane %d, expected %d", point[0], point[1], found, lane)
+		}
+	}
+}
+
+func TestLaneAssignerLane(t *testing.T) {
+	assigner := NewLaneAssigner()
+	assigner.counts[1] = &[4]int{LANE_NONE: 50, LANE_TOP: 10, LANE_BOT: 12}
+	assigner.counts[2] = &[4]int{LANE_NONE: 40}
+
+	if lane := assigner.Lane(1); lane != LANE_BOT {
+		t.Errorf("player seen in the bottom lane most was assigned lane %d", lane)
+	}
+
+	// Junglers and players never seen get mid
+	if lane := assigner.Lane(2); lane != LANE_MID {
+		t.Errorf("player never seen in a lane was assigned lane %d", lane)
+	}
+
+	if lane := assigner.Lane(3); lane != LANE_MID {
+		t.Errorf("player never sampled was assigned lane %d", lane)
+	}
+}
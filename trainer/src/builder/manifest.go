@@ -0,0 +1,334 @@
+package builder
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dotabuff/manta/dota"
+)
+
+/* Bump whenever the example format changes, so old corpora can't silently be appended to. */
+const BUILDER_VERSION = 10
+
+const MANIFEST_FILE = "manifest.json"
+
+/* Number of examples a demo added to one team's corpus of a hero. */
+type ExampleCounts struct {
+	Move  int `json:"move"`
+	Items int `json:"items"`
+}
+
+/* Records one demo (or merged corpora folder) that went into the corpora. */
+type ManifestEntry struct {
+	Demo     string                     `json:"demo"`
+	Hash     string                     `json:"hash"`
+	MatchID  uint64                     `json:"match_id,omitempty"`
+	Version  int                        `json:"version"`
+	Teams    int                        `json:"teams"`             // team compositions it added
+	Examples map[string][]ExampleCounts `json:"examples"`          // hero -> Radiant, Dire
+	Skipped  string                     `json:"skipped,omitempty"` // why the demo added nothing, if it was left out on purpose
+	Patch    string                     `json:"patch,omitempty"`   // patch folder the examples went into, if the corpora are split by patch
+}
+
+/*
+	Lists everything in a corpora folder in the order it was appended, which is also the order its examples are in. That's what
+	lets a rerun skip demos it has already seen and cut out the examples of demos that changed.
+*/
+type Manifest struct {
+	Demos []*ManifestEntry `json:"demos"`
+}
+
+/* Reads the manifest of a corpora folder, or returns nil if it doesn't have one. */
+func LoadManifest(root string) (*Manifest, error) {
+	file, err := os.Open(filepath.Join(root, MANIFEST_FILE))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	manifest := &Manifest{}
+
+	if err := json.NewDecoder(file).Decode(manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func (manifest *Manifest) Write(root string) error {
+	if output, err := json.MarshalIndent(manifest, "", "\t"); err == nil {
+		return ioutil.WriteFile(filepath.Join(root, MANIFEST_FILE), output, 0644)
+	} else {
+		return err
+	}
+}
+
+/* Maps demo paths to their entries. */
+func (manifest *Manifest) ByDemo() map[string]*ManifestEntry {
+	entries := make(map[string]*ManifestEntry)
+
+	for _, entry := range manifest.Demos {
+		entries[entry.Demo] = entry
+	}
+
+	return entries
+}
+
+/* What tells two demos of the same game apart from two different games. */
+type Fingerprint struct {
+	Hash     string              // hex SHA-256 of the (decompressed) demo, "" until it's been read whole (see demoHasher)
+	MatchID  uint64              // 0 if the demo doesn't say
+	FileInfo *dota.CDemoFileInfo // nil if the demo doesn't have one
+}
+
+/* Reads a whole demo and fingerprints it. */
+func FingerprintDemo(demo io.Reader) (Fingerprint, error) {
+	hash := sha256.New()
+	stream := io.TeeReader(demo, hash)
+
+	var fingerprint Fingerprint
+
+	if info, err := ReadFileInfo(stream); err == nil {
+		fingerprint.MatchID = info.GetGameInfo().GetDota().GetMatchId()
+		fingerprint.FileInfo = info
+	} // not being able to find a match ID isn't an error, the demo just can't be deduplicated by it
+
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return fingerprint, err
+	}
+
+	fingerprint.Hash = hex.EncodeToString(hash.Sum(nil))
+	return fingerprint, nil
+}
+
+/*
+	Fingerprints a demo by its file info alone, leaving the hash to be taken while it's parsed (see demoHasher). The file info
+	is only read if the demo can seek to it, since otherwise that's almost as slow as hashing it.
+*/
+func PeekDemo(demo io.Reader) Fingerprint {
+	var fingerprint Fingerprint
+
+	if _, ok := demo.(io.Seeker); ok {
+		if info, err := ReadFileInfo(demo); err == nil {
+			fingerprint.MatchID = info.GetGameInfo().GetDota().GetMatchId()
+			fingerprint.FileInfo = info
+		}
+	}
+
+	return fingerprint
+}
+
+/*
+	Hashes a demo as the parser reads it, so demos that get parsed anyway aren't read once more just to be fingerprinted. Open
+	opens the demo like the open Builder.parse takes; the first stream it opens is hashed, and closing that stream reads
+	whatever the parser left of it so the hash covers the whole demo.
+*/
+type demoHasher struct {
+	open   func() (io.ReadCloser, error)
+	hash   hash.Hash
+	opened bool
+	done   bool
+}
+
+func newDemoHasher(open func() (io.ReadCloser, error)) *demoHasher {
+	return &demoHasher{open: open, hash: sha256.New()}
+}
+
+func (hasher *demoHasher) Open() (io.ReadCloser, error) {
+	demo, err := hasher.open()
+
+	if err != nil || hasher.opened {
+		return demo, err
+	}
+
+	hasher.opened = true
+	return &hashedStream{io.TeeReader(demo, hasher.hash), demo, hasher}, nil
+}
+
+/* The hex SHA-256 of the demo, "" if the stream that was hashed couldn't be read to the end. */
+func (hasher *demoHasher) Sum() string {
+	if !hasher.done {
+		return ""
+	}
+
+	return hex.EncodeToString(hasher.hash.Sum(nil))
+}
+
+type hashedStream struct {
+	io.Reader
+	demo   io.Closer
+	hasher *demoHasher
+}
+
+func (stream *hashedStream) Close() error {
+	_, err := io.Copy(ioutil.Discard, stream.Reader)
+	stream.hasher.done = err == nil
+
+	return FirstError(err, stream.demo.Close())
+}
+
+/* Index ranges of examples to cut out of one corpus file. */
+type dropRange struct {
+	start int
+	end   int
+}
+
+/*
+	Removes the examples and team compositions of the entries drop returns true for from the (closed) corpora in root, along with
+	the entries themselves. Corpora split by patch are each cut in their own folder. Vocabularies are left alone so IDs in the
+	remaining examples stay valid.
+*/
+func (manifest *Manifest) Drop(root string, drop func(*ManifestEntry) bool) error {
+	dropped := make(map[*ManifestEntry]bool)
+	patches := make(map[string]*Manifest)
+	touched := make(map[string]bool) // patches that lose examples
+
+	var kept []*ManifestEntry
+
+	for _, entry := range manifest.Demos {
+		if patches[entry.Patch] == nil {
+			patches[entry.Patch] = &Manifest{}
+		}
+
+		patches[entry.Patch].Demos = append(patches[entry.Patch].Demos, entry)
+
+		if dropped[entry] = drop(entry); !dropped[entry] {
+			kept = append(kept, entry)
+		} else if entry.Skipped == "" {
+			touched[entry.Patch] = true
+		}
+	}
+
+	for patch := range touched {
+		err := patches[patch].drop(PatchRoot(root, patch), func(entry *ManifestEntry) bool {
+			return dropped[entry]
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest.Demos = kept
+	return nil
+}
+
+/* Drop, for the entries of one corpora folder. */
+func (manifest *Manifest) drop(root string, drop func(*ManifestEntry) bool) error {
+	vocab, err := LoadVocabulary(root)
+
+	if err != nil {
+		return err
+	}
+
+	type key struct {
+		hero string
+		team int
+		kind string
+	}
+
+	offsets := make(map[key]int)
+	ranges := make(map[key][]dropRange)
+
+	var teams []map[string]uint64
+	var kept []*ManifestEntry
+
+	teamOffset := 0
+
+	for _, entry := range manifest.Demos {
+		dropped := drop(entry)
+
+		for hero, counts := range entry.Examples {
+			for i, count := range counts {
+				for kind, n := range map[string]int{"move": count.Move, "items": count.Items} {
+					k := key{hero, i + 2, kind}
+
+					if dropped && n > 0 {
+						ranges[k] = append(ranges[k], dropRange{offsets[k], offsets[k] + n})
+					}
+
+					offsets[k] += n
+				}
+			}
+		}
+
+		if !dropped {
+			kept = append(kept, entry)
+
+			if teamOffset+entry.Teams <= len(vocab.Teams) {
+				teams = append(teams, vocab.Teams[teamOffset:teamOffset+entry.Teams]...)
+			}
+		}
+
+		teamOffset += entry.Teams
+	}
+
+	for k, skip := range ranges {
+		if err := dropExamples(CorpusPath(root, k.hero, k.team, k.kind), skip); err != nil {
+			return err
+		}
+	}
+
+	if teams == nil {
+		teams = []map[string]uint64{}
+	}
+
+	vocab.Teams = teams
+
+	if err := vocab.Write(root); err != nil {
+		return err
+	}
+
+	manifest.Demos = kept
+	return nil
+}
+
+/* Rewrites a corpus file without the examples in skip (sorted, as Drop builds them). */
+func dropExamples(path string, skip []dropRange) error {
+	output, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(output)
+	writer.WriteString("[\n")
+
+	index := 0
+
+	err = ReadCorpus(path, func(raw json.RawMessage) error {
+		for len(skip) > 0 && index >= skip[0].end {
+			skip = skip[1:]
+		}
+
+		if len(skip) == 0 || index < skip[0].start {
+			writer.Write(raw)
+			writer.WriteString(",")
+		}
+
+		index++
+		return nil
+	})
+
+	if err == nil {
+		_, err = writer.WriteString("\n]")
+	}
+
+	if err = FirstError(err, writer.Flush(), output.Close()); err != nil {
+		os.Remove(output.Name())
+		return err
+	}
+
+	return os.Rename(output.Name(), path)
+}
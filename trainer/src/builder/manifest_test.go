@@ -0,0 +1,152 @@
+package builder
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+/* A fake demo of size bytes, told apart from others of its size by its last byte. */
+func testDemo(size int, last byte) []byte {
+	demo := make([]byte, size)
+	copy(demo, DEMO_STAMP)
+	demo[size-1] = last
+
+	return demo
+}
+
+func TestFingerprintDemo(t *testing.T) {
+	size := 1<<20 + 100
+	fingerprint, err := FingerprintDemo(bytes.NewReader(testDemo(size, 1)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fingerprint.MatchID != 0 || fingerprint.FileInfo != nil {
+		t.Errorf("found a match in a demo without file info: %+v", fingerprint)
+	}
+
+	if same, _ := FingerprintDemo(bytes.NewReader(testDemo(size, 1))); same.Hash != fingerprint.Hash {
+		t.Errorf("the same demo got different hashes")
+	}
+
+	// Changes anywhere in the demo, however far in, have to show up
+	if other, _ := FingerprintDemo(bytes.NewReader(testDemo(size, 2))); other.Hash == fingerprint.Hash {
+		t.Errorf("demos that differ in their last byte got the same hash")
+	}
+
+	if other, _ := FingerprintDemo(iotest.OneByteReader(bytes.NewReader(testDemo(size+1, 1)))); other.Hash == fingerprint.Hash {
+		t.Errorf("demos of different sizes got the same hash")
+	}
+}
+
+func TestDemoHasher(t *testing.T) {
+	demo := testDemo(1<<20+100, 1)
+	expected, _ := FingerprintDemo(bytes.NewReader(demo))
+
+	opened := 0
+	hasher := newDemoHasher(func() (io.ReadCloser, error) {
+		opened++
+		return ioutil.NopCloser(bytes.NewReader(demo)), nil
+	})
+
+	// Like a two pass parse: the first pass stops early, the second reads it all
+	for _, read := range []int64{1000, int64(len(demo))} {
+		stream, err := hasher.Open()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, stream, read); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stream.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if opened != 2 {
+		t.Errorf("opened the demo %d times", opened)
+	}
+
+	if hash := hasher.Sum(); hash != expected.Hash {
+		t.Errorf("hashed %s while parsing, %s when reading the demo whole", hash, expected.Hash)
+	}
+
+	unread := newDemoHasher(func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(demo)), nil })
+
+	if _, err := unread.Open(); err != nil || unread.Sum() != "" {
+		t.Errorf("hashed a demo that wasn't read to the end")
+	}
+}
+
+func TestPeekDemo(t *testing.T) {
+	demo := testDemo(1000, 1)
+
+	if fingerprint := PeekDemo(bytes.NewReader(demo)); fingerprint.Hash != "" || fingerprint.FileInfo != nil {
+		t.Errorf("peeking found %+v in a demo without file info", fingerprint)
+	}
+
+	// Streams that can't seek aren't read at all
+	stream := bytes.NewBuffer(demo)
+	PeekDemo(stream)
+
+	if stream.Len() != len(demo) {
+		t.Errorf("peeking read %d bytes of a stream", len(demo)-stream.Len())
+	}
+}
+
+func TestManifestDrop(t *testing.T) {
+	root := writeTestCorpora(t, func(corpus *Corpus) []*MoveExample {
+		return []*MoveExample{testOrder(1, false), testOrder(2, false), testOrder(3, false)}
+	})
+
+	defer os.RemoveAll(root)
+
+	vocab, err := LoadVocabulary(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vocab.Teams = []map[string]uint64{{TEST_HERO: 2}, {TEST_HERO: 3}}
+
+	if err := vocab.Write(root); err != nil {
+		t.Fatal(err)
+	}
+
+	// a added the first example, b the other two, c nothing
+	entry := func(demo string, moves int, teams int) *ManifestEntry {
+		return &ManifestEntry{Demo: demo, Teams: teams, Examples: map[string][]ExampleCounts{TEST_HERO: {{Move: moves}, {}}}}
+	}
+
+	manifest := &Manifest{Demos: []*ManifestEntry{entry("a", 1, 1), entry("b", 2, 1), {Demo: "c", Skipped: "no heroes"}}}
+
+	err = manifest.Drop(root, func(entry *ManifestEntry) bool {
+		return entry.Demo != "a"
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Demos) != 1 || manifest.Demos[0].Demo != "a" {
+		t.Errorf("kept %d entries", len(manifest.Demos))
+	}
+
+	if examples := readTestExamples(t, root); len(examples) != 1 || examples[0].DotaTime != 1 {
+		t.Errorf("kept %d examples", len(examples))
+	}
+
+	if vocab, err := LoadVocabulary(root); err != nil {
+		t.Fatal(err)
+	} else if len(vocab.Teams) != 1 || vocab.Teams[0][TEST_HERO] != 2 {
+		t.Errorf("kept teams %v", vocab.Teams)
+	}
+}
@@ -0,0 +1,228 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+/*
+	Looks up the name behind an ID handed out by GetID.
+
+	Offset is how far the value stored in the example is from the one stored in the vocabulary: 1 for plain item IDs and 2 for
+	AbilityUsed/ItemUsed (which are shifted once more so that 1 can mean "none").
+*/
+func LookupID(dict map[string]int, id int, offset int) (string, bool) {
+	for name, stored := range dict {
+		if stored+offset == id {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+/* Translates an item ID from one vocabulary to another. */
+func RemapItem(from *Observed, to *Observed, id int) (int, error) {
+	if name, ok := LookupID(from.ObservedItems, id, 1); ok {
+		return GetID(to.ObservedItems, name), nil
+	}
+
+	return 0, fmt.Errorf("unknown item id %d", id)
+}
+
+/* Translates an AbilityUsed/ItemUsed label, where 1 means nothing was used and 0 that it isn't known what was. */
+func RemapActive(from map[string]int, to map[string]int, id int) (int, error) {
+	if id <= 1 {
+		return id, nil
+	}
+
+	if name, ok := LookupID(from, id, 2); ok {
+		return GetID(to, name) + 1, nil
+	}
+
+	return 0, fmt.Errorf("unknown ability/item id %d", id)
+}
+
+/* Rewrites every vocabulary ID in a move example. */
+func (example *MoveExample) Remap(from *Observed, to *Observed) error {
+	var err error
+
+	for i, item := range example.CurrentItems {
+		if example.CurrentItems[i], err = RemapItem(from, to, item); err != nil {
+			return err
+		}
+	}
+
+	if example.AbilityUsed, err = RemapActive(from.ObservedActiveAbilities, to.ObservedActiveAbilities, example.AbilityUsed); err != nil {
+		return err
+	}
+
+	if example.ItemUsed, err = RemapActive(from.ObservedActiveItems, to.ObservedActiveItems, example.ItemUsed); err != nil {
+		return err
+	}
+
+	for i := range example.Sequence {
+		order := &example.Sequence[i]
+
+		if order.AbilityUsed, err = RemapActive(from.ObservedActiveAbilities, to.ObservedActiveAbilities, order.AbilityUsed); err != nil {
+			return err
+		}
+
+		if order.ItemUsed, err = RemapActive(from.ObservedActiveItems, to.ObservedActiveItems, order.ItemUsed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Rewrites every vocabulary ID in a build example. */
+func (example *BuildExample) Remap(from *Observed, to *Observed) error {
+	inventory := make(map[int]struct{})
+
+	for item := range example.CurrentInventory {
+		id, err := RemapItem(from, to, item)
+
+		if err != nil {
+			return err
+		}
+
+		inventory[id] = struct{}{}
+	}
+
+	example.CurrentInventory = inventory
+
+	for i, item := range example.NewItems {
+		id, err := RemapItem(from, to, item)
+
+		if err != nil {
+			return err
+		}
+
+		example.NewItems[i] = id
+	}
+
+	return nil
+}
+
+/*
+	Appends the corpora of an existing build output folder to these corpora, translating its example IDs into our vocabularies.
+	Heroes are merged in sorted order so the result only depends on the order folders are merged in. Corpora from different
+	patches or with different feature groups are never mixed: empty corpora take the patch and features of the first folder
+	merged into them, after that they have to match.
+
+	Returns a manifest entry (without demo, hash or version) saying how many examples and team compositions were added.
+*/
+func (corpora *Corpora) Merge(root string) (*ManifestEntry, error) {
+	return corpora.MergeWith(root, nil, nil)
+}
+
+/*
+	Merge, but the corpus files are opened with open, if given (see ReadCorpusWith), and every example (a *MoveExample or
+	*BuildExample) is passed through adjust, if given, before being written.
+*/
+func (corpora *Corpora) MergeWith(root string, open func(path string) (io.ReadCloser, error), adjust func(example interface{})) (*ManifestEntry, error) {
+	vocab, err := LoadVocabulary(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	features, err := LoadFeatureSchema(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(corpora.Corpora) == 0 && len(corpora.Teams) == 0 && corpora.Patch == "" && len(corpora.Features.Groups) == 0 {
+		corpora.Patch = vocab.Patch
+		corpora.Features = features
+	} else if vocab.Patch != corpora.Patch {
+		return nil, fmt.Errorf("%s has examples from %s, which can't be mixed into corpora from %s", root, describePatch(vocab.Patch), describePatch(corpora.Patch))
+	} else if !features.Equal(corpora.Features) {
+		return nil, fmt.Errorf("%s has examples with %s, which can't be mixed into corpora with %s", root, features, corpora.Features)
+	}
+
+	entry := &ManifestEntry{Teams: len(vocab.Teams), Examples: make(map[string][]ExampleCounts)}
+
+	heroes := make([]string, 0, len(vocab.Heroes))
+
+	for hero := range vocab.Heroes {
+		heroes = append(heroes, hero)
+	}
+
+	sort.Strings(heroes)
+
+	for _, hero := range heroes {
+		corpus, err := corpora.GetCorpus(hero)
+
+		if err != nil {
+			return nil, err
+		}
+
+		counts := make([]ExampleCounts, len(vocab.Heroes[hero]))
+		entry.Examples[hero] = counts
+
+		for i := range vocab.Heroes[hero] {
+			from := &vocab.Heroes[hero][i]
+			to := &corpus[i].Observed
+
+			// Cooldowns are positional, so the ability list can only grow
+			if len(from.ObservedAbilities) > len(to.ObservedAbilities) {
+				to.ObservedAbilities = append(to.ObservedAbilities, from.ObservedAbilities[len(to.ObservedAbilities):]...)
+			}
+
+			err := ReadCorpusWith(CorpusPath(root, hero, i+2, "move"), open, func(raw json.RawMessage) error {
+				example := &MoveExample{}
+
+				if err := json.Unmarshal(raw, example); err != nil {
+					return err
+				}
+
+				if err := example.Remap(from, to); err != nil {
+					return err
+				}
+
+				if adjust != nil {
+					adjust(example)
+				}
+
+				counts[i].Move++
+				return WriteToCorpus(example, corpus[i].Move)
+			})
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = ReadCorpusWith(CorpusPath(root, hero, i+2, "items"), open, func(raw json.RawMessage) error {
+				example := &BuildExample{}
+
+				if err := json.Unmarshal(raw, example); err != nil {
+					return err
+				}
+
+				if err := example.Remap(from, to); err != nil {
+					return err
+				}
+
+				if adjust != nil {
+					adjust(example)
+				}
+
+				counts[i].Items++
+				return WriteToCorpus(example, corpus[i].Item)
+			})
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	corpora.Teams = append(corpora.Teams, vocab.Teams...)
+
+	return entry, nil
+}
@@ -0,0 +1,238 @@
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const TEST_HERO = "npc_dota_hero_axe"
+
+/* Writes a corpora folder with the given Radiant move examples for TEST_HERO, their IDs in corpus' vocabularies. */
+func writeTestCorpora(t *testing.T, fill func(corpus *Corpus) []*MoveExample) string {
+	root, err := ioutil.TempDir("", "corpora")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corpora := NewCorpora(root)
+	corpus, err := corpora.GetCorpus(TEST_HERO)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, example := range fill(corpus[0]) {
+		if err := WriteToCorpus(example, corpus[0].Move); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := corpora.CloseCorpora(""); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+/* Reads back the Radiant move examples of TEST_HERO. */
+func readTestExamples(t *testing.T, root string) []*MoveExample {
+	examples := []*MoveExample{}
+
+	err := ReadCorpus(CorpusPath(root, TEST_HERO, 2, "move"), func(raw json.RawMessage) error {
+		example := &MoveExample{}
+		examples = append(examples, example)
+		return json.Unmarshal(raw, example)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return examples
+}
+
+func TestMergeRemapsQueuedOrders(t *testing.T) {
+	var berserkersCall, blinkDagger int
+
+	source := writeTestCorpora(t, func(corpus *Corpus) []*MoveExample {
+		// Taken first so the IDs in the source differ from the ones the merge hands out
+		GetID(corpus.ObservedActiveAbilities, "axe_battle_hunger")
+		GetID(corpus.ObservedActiveItems, "item_tango")
+
+		berserkersCall = GetID(corpus.ObservedActiveAbilities, "axe_berserkers_call") + 1
+		blinkDagger = GetID(corpus.ObservedActiveItems, "item_blink") + 1
+
+		example := &MoveExample{}
+		example.MoveOutputLabels = MoveOutputLabels{AbilityUsed: 1, ItemUsed: 1, Action: ActionMoveToLocation}
+		example.Sequence = []MoveOutputExample{
+			{MoveOutputLabels: MoveOutputLabels{AbilityUsed: 1, ItemUsed: blinkDagger, Action: ActionUseAbilityOnLocation, Queued: 1}},
+			{MoveOutputLabels: MoveOutputLabels{AbilityUsed: berserkersCall, ItemUsed: 1, Action: ActionUseAbility, Queued: 1}},
+		}
+
+		return []*MoveExample{example}
+	})
+
+	defer os.RemoveAll(source)
+
+	target := writeTestCorpora(t, func(corpus *Corpus) []*MoveExample { return nil })
+	defer os.RemoveAll(target)
+
+	corpora := NewCorpora(target)
+
+	if err := corpora.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := corpora.Merge(source); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := corpora.CloseCorpora(""); err != nil {
+		t.Fatal(err)
+	}
+
+	examples := readTestExamples(t, target)
+
+	if len(examples) != 1 || len(examples[0].Sequence) != 2 {
+		t.Fatalf("expected one example with two queued orders, got %d", len(examples))
+	}
+
+	vocab, err := LoadVocabulary(target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observed := vocab.Heroes[TEST_HERO][0]
+	sequence := examples[0].Sequence
+
+	if expected := observed.ObservedActiveItems["item_blink"] + 2; sequence[0].ItemUsed != expected || expected == blinkDagger {
+		t.Errorf("queued item %d, expected %d (was %d before the merge)", sequence[0].ItemUsed, expected, blinkDagger)
+	}
+
+	if expected := observed.ObservedActiveAbilities["axe_berserkers_call"] + 2; sequence[1].AbilityUsed != expected || expected == berserkersCall {
+		t.Errorf("queued ability %d, expected %d (was %d before the merge)", sequence[1].AbilityUsed, expected, berserkersCall)
+	}
+
+	if sequence[0].AbilityUsed != 1 || sequence[1].ItemUsed != 1 {
+		t.Errorf("unused ability/item labels changed: %+v", sequence)
+	}
+
+	for _, err := range ValidateCorpora(target) {
+		t.Error(err)
+	}
+}
+
+/* Marks a corpora folder as holding examples from patch. */
+func setTestPatch(t *testing.T, root string, patch string) {
+	vocab, err := LoadVocabulary(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vocab.Patch = patch
+
+	if err := vocab.Write(root); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeKeepsPatchesApart(t *testing.T) {
+	fill := func(corpus *Corpus) []*MoveExample { return []*MoveExample{{}} }
+	older := writeTestCorpora(t, fill)
+	newer := writeTestCorpora(t, fill)
+
+	defer os.RemoveAll(older)
+	defer os.RemoveAll(newer)
+
+	setTestPatch(t, older, "7.33c")
+	setTestPatch(t, newer, "7.34")
+
+	target, err := ioutil.TempDir("", "corpora")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(target)
+
+	// Empty corpora take the patch of whatever's merged into them first
+	corpora := NewCorpora(target)
+
+	if _, err := corpora.Merge(older); err != nil {
+		t.Fatal(err)
+	}
+
+	if corpora.Patch != "7.33c" {
+		t.Errorf("merged corpora are from %q", corpora.Patch)
+	}
+
+	if _, err := corpora.Merge(newer); err == nil {
+		t.Errorf("merged 7.34 examples into 7.33c corpora")
+	}
+
+	if err := corpora.CloseCorpora(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if vocab, err := LoadVocabulary(target); err != nil || vocab.Patch != "7.33c" {
+		t.Errorf("vocabulary says the corpora are from %v (%v)", vocab, err)
+	}
+
+	if examples := readTestExamples(t, target); len(examples) != 1 {
+		t.Errorf("%d examples after merging, expected the one from 7.33c", len(examples))
+	}
+}
+
+func TestMergeKeepsFeatureSchemasApart(t *testing.T) {
+	fill := func(corpus *Corpus) []*MoveExample { return []*MoveExample{{}} }
+	plain := writeTestCorpora(t, fill)
+	featured := writeTestCorpora(t, fill)
+
+	defer os.RemoveAll(plain)
+	defer os.RemoveAll(featured)
+
+	schema, err := NewFeatureSchema(FeatureOptions{Groups: []string{"gold", "kda"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schema.Write(featured); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := ioutil.TempDir("", "corpora")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(target)
+
+	corpora := NewCorpora(target)
+
+	if _, err := corpora.Merge(featured); err != nil {
+		t.Fatal(err)
+	}
+
+	if !corpora.Features.Equal(schema) {
+		t.Errorf("merged corpora have %s, expected %s", corpora.Features, schema)
+	}
+
+	if _, err := corpora.Merge(plain); err == nil {
+		t.Errorf("merged examples without feature groups into corpora with %s", schema)
+	}
+
+	if err := corpora.CloseCorpora(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := LoadFeatureSchema(target); err != nil || !loaded.Equal(schema) {
+		t.Errorf("%s says the corpora have %s (%v)", FEATURES_FILE, loaded, err)
+	}
+}
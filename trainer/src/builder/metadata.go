@@ -0,0 +1,161 @@
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/dotabuff/manta/dota"
+)
+
+/* Where a parse leaves the metadata of its demo, in its scratch corpora folder. */
+const MATCH_FILE = "match.json"
+
+/* Folder of the output with one metadata file per demo. */
+const MATCHES_FOLDER = "matches"
+
+type PickBan struct {
+	IsPick bool   `json:"is_pick"`
+	Team   uint32 `json:"team"`
+	HeroID uint32 `json:"hero_id"`
+}
+
+/* What's known about the match a demo recorded, so training data can be filtered and audited without reparsing. */
+type MatchMetadata struct {
+	Demo        string       `json:"demo"`
+	Hash        string       `json:"hash"`
+	MatchID     uint64       `json:"match_id"`
+	Build       uint32       `json:"build"`
+	Patch       string       `json:"patch,omitempty"`
+	GameMode    int32        `json:"game_mode"`
+	LobbyType   int32        `json:"lobby_type"`
+	Duration    float32      `json:"duration"`     // seconds from the horn to the end of the game
+	WinningTeam int32        `json:"winning_team"` // 2 (Radiant) or 3 (Dire)
+	StartTime   uint32       `json:"start_tick"`   // horn
+	PicksBans   []PickBan    `json:"picks_bans"`
+	Selected    []*TopPlayer `json:"selected_players"`
+}
+
+/* Collects the metadata the parse found out. Demo and hash are only known once it's merged. */
+func NewMatchMetadata(info *MatchInfo, patches PatchTable) *MatchMetadata {
+	metadata := &MatchMetadata{
+		MatchID:     info.MatchID,
+		Build:       info.Build,
+		Patch:       patches.Lookup(info.Build),
+		GameMode:    info.GameMode,
+		LobbyType:   info.LobbyType,
+		WinningTeam: info.WinningTeam,
+		StartTime:   info.StartTime,
+		PicksBans:   []PickBan{},
+		Selected:    []*TopPlayer{},
+	}
+
+	if info.EndTime > info.StartTime {
+		metadata.Duration = float32(info.EndTime-info.StartTime) / TICKRATE
+	}
+
+	for _, player := range info.Top {
+		metadata.Selected = append(metadata.Selected, player)
+	}
+
+	sort.Slice(metadata.Selected, func(i, j int) bool {
+		return metadata.Selected[i].ID < metadata.Selected[j].ID
+	})
+
+	return metadata
+}
+
+/* Fills in what only the demo's file info has (picks and bans), and whatever the parse couldn't find. */
+func (metadata *MatchMetadata) AddFileInfo(info *dota.CDemoFileInfo) {
+	game := info.GetGameInfo().GetDota()
+
+	if game == nil {
+		return
+	}
+
+	if metadata.MatchID == 0 {
+		metadata.MatchID = game.GetMatchId()
+	}
+
+	if metadata.GameMode == 0 {
+		metadata.GameMode = game.GetGameMode()
+	}
+
+	if metadata.WinningTeam == 0 {
+		metadata.WinningTeam = game.GetGameWinner()
+	}
+
+	for _, event := range game.GetPicksBans() {
+		metadata.PicksBans = append(metadata.PicksBans, PickBan{event.GetIsPick(), event.GetTeam(), event.GetHeroId()})
+	}
+}
+
+/* Where the metadata of a demo goes in an output folder: named after its match, or its hash if the match isn't known. */
+func MatchPath(root string, matchID uint64, hash string) string {
+	name := hash
+
+	if matchID != 0 {
+		name = strconv.FormatUint(matchID, 10)
+	} else if len(name) > 16 {
+		name = name[:16]
+	}
+
+	return filepath.Join(root, MATCHES_FOLDER, name+".json")
+}
+
+/* Reads a metadata file, or returns nil if there isn't one. */
+func LoadMatchMetadata(path string) (*MatchMetadata, error) {
+	input, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	metadata := &MatchMetadata{}
+
+	if err := json.Unmarshal(input, metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func (metadata *MatchMetadata) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 493); err != nil {
+		return err
+	}
+
+	if output, err := json.MarshalIndent(metadata, "", "\t"); err == nil {
+		return ioutil.WriteFile(path, output, 0644)
+	} else {
+		return err
+	}
+}
+
+/* Copies the metadata files of another output folder into root. */
+func CopyMatches(from string, root string) error {
+	files, err := filepath.Glob(filepath.Join(from, MATCHES_FOLDER, "*.json"))
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		metadata, err := LoadMatchMetadata(file)
+
+		if err != nil {
+			return err
+		}
+
+		if err := metadata.Write(filepath.Join(root, MATCHES_FOLDER, filepath.Base(file))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
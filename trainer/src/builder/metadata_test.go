@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewMatchMetadata(t *testing.T) {
+	info := &MatchInfo{
+		StartTime:   900,
+		EndTime:     900 + 40*60*TICKRATE,
+		WinningTeam: 3,
+		Top:         map[int32]*TopPlayer{7: {ID: 7, Team: 3}, 2: {ID: 2, Team: 2}},
+		MatchID:     7000000001,
+		GameMode:    22,
+	}
+
+	metadata := NewMatchMetadata(info, nil)
+
+	if metadata.Duration != 40*60 {
+		t.Errorf("duration %f, expected 40 minutes", metadata.Duration)
+	}
+
+	if len(metadata.Selected) != 2 || metadata.Selected[0].ID != 2 || metadata.Selected[1].ID != 7 {
+		t.Errorf("selected players %v aren't sorted by ID", metadata.Selected)
+	}
+
+	if metadata.MatchID != info.MatchID || metadata.WinningTeam != 3 || metadata.StartTime != 900 || metadata.Patch != "" {
+		t.Errorf("metadata %+v doesn't match %+v", metadata, info)
+	}
+
+	// A game that never ended has no duration
+	info.EndTime = 0
+
+	if metadata := NewMatchMetadata(info, nil); metadata.Duration != 0 {
+		t.Errorf("duration %f for a game that never ended", metadata.Duration)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	expected := map[string]string{
+		MatchPath("out", 7000000001, "0123456789abcdef0123"): "out/matches/7000000001.json",
+		MatchPath("out", 0, "0123456789abcdef0123"):          "out/matches/0123456789abcdef.json",
+		MatchPath("out", 0, "0123"):                          "out/matches/0123.json",
+	}
+
+	for path, want := range expected {
+		if filepath.ToSlash(path) != want {
+			t.Errorf("got %s, expected %s", path, want)
+		}
+	}
+}
+
+func TestMatchMetadataRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matches")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "from")
+	path := MatchPath(from, 7000000001, "")
+
+	if metadata, err := LoadMatchMetadata(path); metadata != nil || err != nil {
+		t.Fatalf("loading a missing file gave %v, %v", metadata, err)
+	}
+
+	metadata := &MatchMetadata{
+		Demo:      "a.dem",
+		Hash:      "0123",
+		MatchID:   7000000001,
+		PicksBans: []PickBan{{true, 2, 14}, {false, 3, 1}},
+		Selected:  []*TopPlayer{{ID: 2, Team: 2, Name: "someone"}},
+	}
+
+	if err := metadata.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	to := filepath.Join(dir, "to")
+
+	if err := CopyMatches(from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := LoadMatchMetadata(MatchPath(to, 7000000001, ""))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(copied, metadata) {
+		t.Errorf("read back %+v, expected %+v", copied, metadata)
+	}
+}
@@ -0,0 +1,195 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+/* Where the modifier vocabulary the bots load goes, next to ability_data.lua. */
+const MODIFIER_DATA_FILE = "modifier_data.lua"
+
+/* Modifiers the modifiers feature group looks for by default: stuns, silences, hexes, invisibility and spell immunity. */
+var DEFAULT_MODIFIERS = []string{
+	"modifier_stunned",
+	"modifier_bashed",
+	"modifier_rooted",
+	"modifier_disarmed",
+	"modifier_silence",
+	"modifier_item_silver_edge_windwalk",
+	"modifier_sheepstick_debuff",
+	"modifier_lion_voodoo",
+	"modifier_shadow_shaman_voodoo",
+	"modifier_invisible",
+	"modifier_rune_invis",
+	"modifier_item_invisibility_edge_windwalk",
+	"modifier_black_king_bar_immune",
+	"modifier_smoke_of_deceit",
+	"modifier_teleporting",
+}
+
+/* How many of the nearest enemy heroes the modifiers feature group looks at by default. */
+const DEFAULT_MODIFIER_ENEMIES = 2
+
+/* Feature names of the modifiers feature group. */
+func modifierFeatures(vocabulary []string, enemies int) []string {
+	features := []string{}
+
+	for _, modifier := range vocabulary {
+		features = append(features, fmt.Sprintf("1 if the hero has %s, else 0 (HasModifier)", modifier))
+	}
+
+	for i := 1; i <= enemies; i++ {
+		for _, modifier := range vocabulary {
+			features = append(features, fmt.Sprintf("1 if the nearest visible enemy hero #%d has %s, else 0 (GetNearbyHeroes(%g, true)[%d]:HasModifier)", i, modifier, MAX_NEARBY_RADIUS, i))
+		}
+	}
+
+	return features
+}
+
+/*
+	Follows the modifiers in the modifiers feature group's vocabulary through the replay's modifier table, by the entindex of the
+	unit they're on. Other modifiers are ignored.
+*/
+type Modifiers struct {
+	vocabulary map[string]int          // slot of every modifier looked for
+	active     map[int32]map[int32]int // slot of every active modifier looked for, by unit entindex and modifier index
+	size       int                     // of the vocabulary
+	nearest    int                     // how many of the nearest enemy heroes are looked at
+}
+
+/* Follows the modifiers the schema's modifiers group looks for, none if it hasn't got one. */
+func NewModifiers(schema FeatureSchema) *Modifiers {
+	modifiers := &Modifiers{vocabulary: make(map[string]int), active: make(map[int32]map[int32]int)}
+
+	for _, group := range schema.Groups {
+		if group.Name == "modifiers" {
+			for slot, modifier := range group.Modifiers {
+				modifiers.vocabulary[modifier] = slot
+			}
+
+			modifiers.size = len(group.Modifiers)
+			modifiers.nearest = group.Enemies
+		}
+	}
+
+	return modifiers
+}
+
+/* Keeps track of a modifier table entry being added, updated or removed. */
+func (modifiers *Modifiers) Update(parser *manta.Parser, msg *dota.CDOTAModifierBuffTableEntry) error {
+	if modifiers.size == 0 {
+		return nil
+	}
+
+	parent := Handle(uint64(msg.GetParent()))
+
+	if msg.GetEntryType() == dota.DOTA_MODIFIER_ENTRY_TYPE_DOTA_MODIFIER_ENTRY_TYPE_REMOVED {
+		modifiers.remove(parent, msg.GetIndex())
+	} else if name, ok := parser.LookupStringByIndex("ModifierNames", msg.GetModifierClass()); ok {
+		modifiers.add(parent, msg.GetIndex(), name)
+	}
+
+	return nil
+}
+
+/* Keeps track of a modifier with the given index and name being added to (or updated on) a unit, if it's looked for. */
+func (modifiers *Modifiers) add(entindex int32, index int32, name string) {
+	if slot, ok := modifiers.vocabulary[name]; ok {
+		if modifiers.active[entindex] == nil {
+			modifiers.active[entindex] = make(map[int32]int)
+		}
+
+		modifiers.active[entindex][index] = slot
+	}
+}
+
+/* Keeps track of the modifier with the given index being removed from a unit. */
+func (modifiers *Modifiers) remove(entindex int32, index int32) {
+	if active := modifiers.active[entindex]; active != nil {
+		delete(active, index)
+	}
+}
+
+/* Forgets the modifiers of a unit that's been deleted. */
+func (modifiers *Modifiers) Forget(entindex int32) {
+	delete(modifiers.active, entindex)
+}
+
+/* Multi-hot vector of the modifiers looked for that a unit has. */
+func (modifiers *Modifiers) Vector(entindex int32) []float32 {
+	vector := make([]float32, modifiers.size)
+
+	for _, slot := range modifiers.active[entindex] {
+		vector[slot] = 1
+	}
+
+	return vector
+}
+
+/*
+	The modifiers of the hero, then of the nearest enemy heroes its team can see within MAX_NEARBY_RADIUS, nearest first, like
+	GetNearbyHeroes sorts them. Enemies that aren't there get no modifiers.
+*/
+func ModifierFeatures(context *FeatureContext) []float32 {
+	modifiers := context.Modifiers
+	features := modifiers.Vector(context.Hero.GetIndex())
+
+	type enemy struct {
+		entindex int32
+		distance float32
+	}
+
+	enemies := []enemy{}
+
+	if context.Units != nil {
+		coords := GetLocation(context.Hero)
+
+		context.Units.Nearby(context.Parser, coords[0], coords[1], MAX_NEARBY_RADIUS, func(ent *manta.Entity, kind int, team uint64, distance float32) {
+			if kind == UnitHero && team != context.Team && IsVisible(ent, context.Team) {
+				enemies = append(enemies, enemy{ent.GetIndex(), distance})
+			}
+		})
+	}
+
+	sort.Slice(enemies, func(i, j int) bool { return enemies[i].distance < enemies[j].distance })
+
+	for i := 0; i < modifiers.nearest; i++ {
+		if i < len(enemies) {
+			features = append(features, modifiers.Vector(enemies[i].entindex)...)
+		} else {
+			features = append(features, make([]float32, modifiers.size)...)
+		}
+	}
+
+	return features
+}
+
+/* Writes modifier_data.lua next to ability_data.lua, so the bots can build the same vectors out of HasModifier. */
+func WriteModifierData(vocabPath string, group FeatureGroup) error {
+	if observedFile, err := os.Create(filepath.Join(filepath.Dir(vocabPath), MODIFIER_DATA_FILE)); err == nil {
+		writer := bufio.NewWriter(observedFile)
+
+		writer.WriteString("-- This is an automatically generated file. Do not modify.\n")
+		writer.WriteString("module(\"modifier_data\", package.seeall)\n")
+
+		writer.WriteString("modifiers = {")
+
+		for slot, modifier := range group.Modifiers {
+			writer.WriteString(fmt.Sprintf("[%d]=\"%s\",%s=%d,", slot+1, modifier, modifier, slot+1))
+		}
+
+		writer.WriteString("}\n")
+		writer.WriteString(fmt.Sprintf("enemies = %d\n", group.Enemies))
+
+		return FirstError(writer.Flush(), observedFile.Close())
+	} else {
+		return fmt.Errorf("error creating %s: %s", MODIFIER_DATA_FILE, err)
+	}
+}
@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* The schema of a modifiers group looking for modifiers on the hero and its nearest enemies. */
+func testModifierSchema(t *testing.T, modifiers []string, enemies int) FeatureSchema {
+	schema, err := NewFeatureSchema(FeatureOptions{Groups: []string{"modifiers"}, Modifiers: modifiers, ModifierEnemies: enemies})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+func TestModifiersVector(t *testing.T) {
+	modifiers := NewModifiers(testModifierSchema(t, []string{"modifier_stunned", "modifier_silence", "modifier_invisible"}, 2))
+
+	modifiers.add(7, 1, "modifier_silence")
+	modifiers.add(7, 2, "modifier_stunned")
+	modifiers.add(7, 3, "modifier_flask_healing") // not looked for
+	modifiers.add(8, 1, "modifier_invisible")
+
+	if vector := fmt.Sprint(modifiers.Vector(7)); vector != "[1 1 0]" {
+		t.Errorf("unit 7 has %s", vector)
+	}
+
+	// Modifier indexes are per unit, so removing one off unit 7 leaves unit 8's alone
+	modifiers.remove(7, 1)
+
+	if vector := fmt.Sprint(modifiers.Vector(7)); vector != "[1 0 0]" {
+		t.Errorf("unit 7 has %s after losing its silence", vector)
+	}
+
+	if vector := fmt.Sprint(modifiers.Vector(8)); vector != "[0 0 1]" {
+		t.Errorf("unit 8 has %s", vector)
+	}
+
+	modifiers.Forget(8)
+
+	if vector := fmt.Sprint(modifiers.Vector(8)); vector != "[0 0 0]" {
+		t.Errorf("deleted unit 8 has %s", vector)
+	}
+
+	if vector := modifiers.Vector(9); len(vector) != 3 {
+		t.Errorf("unit without modifiers has %v", vector)
+	}
+}
+
+func TestModifiersWithoutGroup(t *testing.T) {
+	modifiers := NewModifiers(FeatureSchema{})
+	modifiers.add(7, 1, "modifier_stunned")
+
+	if len(modifiers.Vector(7)) != 0 || len(modifiers.active) != 0 {
+		t.Errorf("followed modifiers without a modifiers group")
+	}
+}
+
+func TestModifierFeatureLayout(t *testing.T) {
+	schema := testModifierSchema(t, []string{"modifier_stunned", "modifier_silence"}, 2)
+	group := schema.Groups[0]
+
+	// The hero's own modifiers, then every enemy's
+	if schema.Size() != 6 || group.Enemies != 2 || len(group.Modifiers) != 2 {
+		t.Fatalf("modifiers group has %d features for %d modifiers and %d enemies", schema.Size(), len(group.Modifiers), group.Enemies)
+	}
+
+	if feature := group.Features[3]; feature != "1 if the nearest visible enemy hero #1 has modifier_silence, else 0 (GetNearbyHeroes(1600, true)[1]:HasModifier)" {
+		t.Errorf("fourth feature is %q", feature)
+	}
+}
+
+func TestWriteModifierData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vocab")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	schema := testModifierSchema(t, []string{"modifier_stunned", "modifier_silence"}, 1)
+
+	if err := WriteModifierData(filepath.Join(dir, "ability_data.lua"), schema.Groups[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ioutil.ReadFile(filepath.Join(dir, MODIFIER_DATA_FILE))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "-- This is an automatically generated file. Do not modify.\n" +
+		"module(\"modifier_data\", package.seeall)\n" +
+		"modifiers = {[1]=\"modifier_stunned\",modifier_stunned=1,[2]=\"modifier_silence\",modifier_silence=2,}\n" +
+		"enemies = 1\n"
+
+	if string(output) != expected {
+		t.Errorf("wrote %q, expected %q", output, expected)
+	}
+}
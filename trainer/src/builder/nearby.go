@@ -0,0 +1,251 @@
+package builder
+
+import (
+	"math"
+
+	"github.com/dotabuff/manta"
+)
+
+/* The furthest the bot API's GetNearby* calls look, in world units. */
+const MAX_NEARBY_RADIUS = 1600.0
+
+/* Radii the nearby feature group looks within by default, in world units. */
+var DEFAULT_NEARBY_RADII = []float32{700, 1600}
+
+/* Side of the cells the unit index buckets units into, in world units. */
+const NEARBY_CELL_SIZE = 800.0
+
+/* Scales that bring the nearby features to roughly [0, 1]. */
+const NEARBY_COUNT_SCALE = 10.0
+const NEARBY_HEALTH_SCALE = 10000.0
+
+/* Kinds of units the unit index keeps track of. */
+const (
+	UnitLaneCreep = iota + 1
+	UnitNeutral
+	UnitTower
+	UnitHero
+)
+
+/* The kind of a live unit, 0 for entities the unit index ignores. */
+func UnitKind(ent *manta.Entity) int {
+	switch ent.GetClassName() {
+	case LANE_CREEP:
+		return UnitLaneCreep
+	case JUNGLE_CREEP:
+		return UnitNeutral
+	case TOWER:
+		return UnitTower
+	}
+
+	if IsHero(ent) {
+		return UnitHero
+	}
+
+	return 0
+}
+
+/* Where a unit is in the unit index. */
+type indexedUnit struct {
+	kind int
+	team uint64
+	cell [2]int
+}
+
+/*
+	Spatial index of the live lane creeps, neutrals, towers and heroes, bucketed by where they are on the map so the units near
+	a hero can be found without going through all of them. Only where units are is kept, everything else is read off the entities
+	when they're looked up.
+*/
+type UnitIndex struct {
+	units map[int32]indexedUnit         // by entindex
+	cells map[[2]int]map[int32]struct{} // entindexes by cell
+}
+
+func NewUnitIndex() *UnitIndex {
+	return &UnitIndex{make(map[int32]indexedUnit), make(map[[2]int]map[int32]struct{})}
+}
+
+/* The cell a point (in remapped coordinates) is in. */
+func nearbyCell(x float32, y float32) [2]int {
+	return [2]int{
+		int(float64(x) * (MAX_X - MIN_X) / NEARBY_CELL_SIZE),
+		int(float64(y) * (MAX_Y - MIN_Y) / NEARBY_CELL_SIZE),
+	}
+}
+
+/* Keeps track of a unit being created, moved, killed or deleted. Entities that aren't units are ignored. */
+func (index *UnitIndex) Update(ent *manta.Entity, op manta.EntityOp) {
+	kind := UnitKind(ent)
+
+	if kind == 0 {
+		return
+	}
+
+	lifeState, _ := ent.GetInt32("m_lifeState")
+
+	if op.Flag(manta.EntityOpDeleted) || lifeState != 0 {
+		index.remove(ent.GetIndex())
+		return
+	}
+
+	team, _ := ent.GetUint64("m_iTeamNum")
+	coords := GetLocation(ent)
+	index.place(ent.GetIndex(), kind, team, coords[0], coords[1])
+}
+
+/* Files a live unit under the cell it's in now, given where it is in remapped coordinates. */
+func (index *UnitIndex) place(entindex int32, kind int, team uint64, x float32, y float32) {
+	cell := nearbyCell(x, y)
+
+	if unit, ok := index.units[entindex]; ok && unit.cell == cell {
+		return
+	}
+
+	index.remove(entindex)
+	index.units[entindex] = indexedUnit{kind, team, cell}
+
+	if index.cells[cell] == nil {
+		index.cells[cell] = make(map[int32]struct{})
+	}
+
+	index.cells[cell][entindex] = struct{}{}
+}
+
+func (index *UnitIndex) remove(entindex int32) {
+	if unit, ok := index.units[entindex]; ok {
+		delete(index.cells[unit.cell], entindex)
+
+		if len(index.cells[unit.cell]) == 0 {
+			delete(index.cells, unit.cell)
+		}
+
+		delete(index.units, entindex)
+	}
+}
+
+/* Calls found with every indexed unit within radius (in world units) of a point (in remapped coordinates), and how far it is. */
+func (index *UnitIndex) Nearby(parser *manta.Parser, x float32, y float32, radius float32, found func(ent *manta.Entity, kind int, team uint64, distance float32)) {
+	for _, entindex := range index.candidates(x, y, radius) {
+		ent := parser.FindEntity(entindex)
+
+		if ent == nil {
+			continue
+		}
+
+		coords := GetLocation(ent)
+
+		if distance := worldDistance(x, y, coords[0], coords[1]); distance <= radius {
+			unit := index.units[entindex]
+			found(ent, unit.kind, unit.team, distance)
+		}
+	}
+}
+
+/* The units in every cell that reaches within radius (in world units) of a point (in remapped coordinates), near enough or not. */
+func (index *UnitIndex) candidates(x float32, y float32, radius float32) []int32 {
+	var units []int32
+
+	center := nearbyCell(x, y)
+	reach := int(math.Ceil(float64(radius) / NEARBY_CELL_SIZE))
+
+	for cellX := center[0] - reach; cellX <= center[0]+reach; cellX++ {
+		for cellY := center[1] - reach; cellY <= center[1]+reach; cellY++ {
+			for entindex := range index.cells[[2]int{cellX, cellY}] {
+				units = append(units, entindex)
+			}
+		}
+	}
+
+	return units
+}
+
+/* Distance between two points in remapped coordinates, in world units. */
+func worldDistance(x1 float32, y1 float32, x2 float32, y2 float32) float32 {
+	return float32(math.Hypot(float64(x2-x1)*(MAX_X-MIN_X), float64(y2-y1)*(MAX_Y-MIN_Y)))
+}
+
+/* One of the bot API's GetNearby* calls, as a kind of unit and whose side it's on. */
+type nearbyQuery struct {
+	name  string
+	call  string
+	kind  int
+	enemy bool
+}
+
+/* What the nearby feature group counts within each radius, like the GetNearby* calls FinishMoveThink picks targets with. */
+var NEARBY_QUERIES = []nearbyQuery{
+	{"allied lane creeps", "GetNearbyLaneCreeps(%[1]g, false)", UnitLaneCreep, false},
+	{"enemy lane creeps", "GetNearbyLaneCreeps(%[1]g, true)", UnitLaneCreep, true},
+	{"neutral creeps", "GetNearbyNeutralCreeps(%[1]g)", UnitNeutral, true},
+	{"allied towers", "GetNearbyTowers(%[1]g, false)", UnitTower, false},
+	{"enemy towers", "GetNearbyTowers(%[1]g, true)", UnitTower, true},
+	{"allied heroes", "GetNearbyHeroes(%[1]g, false) without the hero itself", UnitHero, false},
+	{"enemy heroes", "GetNearbyHeroes(%[1]g, true)", UnitHero, true},
+}
+
+/* Feature names of the nearby feature group, with the radius left as %[1]g. */
+func nearbyFeatures() []string {
+	features := []string{}
+
+	for _, query := range NEARBY_QUERIES {
+		features = append(features,
+			query.name+" within %[1]g / 10 ("+query.call+")",
+			"total health of "+query.name+" within %[1]g / 10000 ("+query.call+")",
+			"distance to the nearest of "+query.name+" / %[1]g, 1 if there are none ("+query.call+")",
+		)
+	}
+
+	return features
+}
+
+/*
+	Counts, total health and nearest distance of the units within radius of the hero, for every NEARBY_QUERIES. Like the bot API,
+	enemy and neutral units only count while the hero's team can see them.
+*/
+func NearbyFeatures(context *FeatureContext, radius float32) []float32 {
+	counts := make([]int, len(NEARBY_QUERIES))
+	health := make([]float32, len(NEARBY_QUERIES))
+	nearest := make([]float32, len(NEARBY_QUERIES))
+
+	for i := range nearest {
+		nearest[i] = radius
+	}
+
+	if context.Units != nil {
+		coords := GetLocation(context.Hero)
+
+		context.Units.Nearby(context.Parser, coords[0], coords[1], radius, func(ent *manta.Entity, kind int, team uint64, distance float32) {
+			if ent.GetIndex() == context.Hero.GetIndex() {
+				return
+			}
+
+			enemy := team != context.Team
+
+			if enemy && !IsVisible(ent, context.Team) {
+				return
+			}
+
+			for i, query := range NEARBY_QUERIES {
+				if query.kind == kind && query.enemy == enemy {
+					hp, _ := ent.GetInt32("m_iHealth")
+
+					counts[i]++
+					health[i] += float32(hp)
+
+					if distance < nearest[i] {
+						nearest[i] = distance
+					}
+				}
+			}
+		})
+	}
+
+	features := make([]float32, 0, 3*len(NEARBY_QUERIES))
+
+	for i := range NEARBY_QUERIES {
+		features = append(features, float32(counts[i])/NEARBY_COUNT_SCALE, health[i]/NEARBY_HEALTH_SCALE, nearest[i]/radius)
+	}
+
+	return features
+}
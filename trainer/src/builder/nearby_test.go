@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"math"
+	"testing"
+)
+
+/* A point given in world units, in remapped coordinates. */
+func remapped(x float32, y float32) [2]float32 {
+	return [2]float32{RemapX(x), RemapY(y)}
+}
+
+func TestWorldDistance(t *testing.T) {
+	a, b := remapped(-300, 100), remapped(0, 500)
+
+	if distance := worldDistance(a[0], a[1], b[0], b[1]); math.Abs(float64(distance-500)) > 0.1 {
+		t.Errorf("points 500 apart are %f apart", distance)
+	}
+}
+
+func TestUnitIndexFindsEveryUnitInRange(t *testing.T) {
+	index := NewUnitIndex()
+	units := make(map[int32][2]float32)
+
+	// A grid of units over a corner of the map, some of them right on cell edges
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 20; j++ {
+			entindex := int32(i*20 + j + 1)
+			units[entindex] = remapped(-8000+float32(i)*400, -8000+float32(j)*400)
+			index.place(entindex, UnitLaneCreep, 2, units[entindex][0], units[entindex][1])
+		}
+	}
+
+	for _, radius := range []float32{300, 700, 1600} {
+		for _, center := range [][2]float32{remapped(-6000, -6000), remapped(-7990, -4410), remapped(-5200, -5200)} {
+			candidates := make(map[int32]bool)
+
+			for _, entindex := range index.candidates(center[0], center[1], radius) {
+				candidates[entindex] = true
+			}
+
+			for entindex, unit := range units {
+				if worldDistance(center[0], center[1], unit[0], unit[1]) <= radius && !candidates[entindex] {
+					t.Errorf("unit %d within %g of %v isn't a candidate", entindex, radius, center)
+				}
+			}
+
+			if len(candidates) == len(units) {
+				t.Errorf("every unit is a candidate within %g of %v", radius, center)
+			}
+		}
+	}
+}
+
+func TestUnitIndexMovesAndRemovesUnits(t *testing.T) {
+	index := NewUnitIndex()
+	start, end := remapped(-6000, -6000), remapped(6000, 6000)
+
+	index.place(1, UnitHero, 3, start[0], start[1])
+	index.place(1, UnitHero, 3, end[0], end[1])
+
+	if found := index.candidates(start[0], start[1], 700); len(found) != 0 {
+		t.Errorf("hero that walked away is still found where it was: %v", found)
+	}
+
+	if found := index.candidates(end[0], end[1], 700); len(found) != 1 || found[0] != 1 {
+		t.Errorf("found %v where the hero walked to", found)
+	}
+
+	index.remove(1)
+
+	if len(index.units) != 0 || len(index.cells) != 0 {
+		t.Errorf("%d units in %d cells left after removing the only one", len(index.units), len(index.cells))
+	}
+}
+
+func TestNearbyFeatureLayout(t *testing.T) {
+	schema, err := NewFeatureSchema(FeatureOptions{Groups: []string{"nearby", "gold"}, NearbyRadii: []float32{700, 1600}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nearby := schema.Groups[1]
+
+	if nearby.Name != "nearby" || nearby.Offset != 2 || len(nearby.Features) != 2*3*len(NEARBY_QUERIES) {
+		t.Fatalf("nearby group %s at %d with %d features", nearby.Name, nearby.Offset, len(nearby.Features))
+	}
+
+	if first, last := nearby.Features[0], nearby.Features[len(nearby.Features)-1]; first != "allied lane creeps within 700 / 10 (GetNearbyLaneCreeps(700, false))" || last != "distance to the nearest of enemy heroes / 1600, 1 if there are none (GetNearbyHeroes(1600, true))" {
+		t.Errorf("features go from %q to %q", first, last)
+	}
+}
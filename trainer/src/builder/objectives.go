@@ -0,0 +1,235 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+/* More useful classnames. */
+const BARRACKS = "CDOTA_BaseNPC_Barracks"
+const ROSHAN = "CDOTA_Unit_Roshan"
+
+/* Rune types (m_iRuneType), as far as telling bounty runes apart goes. */
+const RUNE_BOUNTY = 5
+
+/* Seconds after a kill Roshan respawns, at the earliest and at the latest. */
+const ROSHAN_RESPAWN_MIN = 8 * 60.0
+const ROSHAN_RESPAWN_MAX = 11 * 60.0
+
+/* Buildings in the order of the bot API's TOWER_* and BARRACKS_* constants, as they appear in their Hammer names. */
+var TOWER_NAMES = []string{
+	"tower1_top", "tower2_top", "tower3_top",
+	"tower1_mid", "tower2_mid", "tower3_mid",
+	"tower1_bot", "tower2_bot", "tower3_bot",
+	"tower4_top", "tower4_bot",
+}
+
+var BARRACKS_NAMES = []string{
+	"rax_melee_top", "rax_range_top",
+	"rax_melee_mid", "rax_range_mid",
+	"rax_melee_bot", "rax_range_bot",
+}
+
+/* Slot of a building in its team's TOWER_NAMES, BARRACKS_NAMES and then its ancient, by Hammer name. -1 if it isn't one. */
+func buildingSlot(classname string, name string) int {
+	switch classname {
+	case TOWER:
+		for i, suffix := range TOWER_NAMES {
+			if strings.HasSuffix(name, suffix) {
+				return i
+			}
+		}
+	case BARRACKS:
+		for i, suffix := range BARRACKS_NAMES {
+			if strings.HasSuffix(name, suffix) {
+				return len(TOWER_NAMES) + i
+			}
+		}
+	case ANCIENT:
+		return len(TOWER_NAMES) + len(BARRACKS_NAMES)
+	}
+
+	return -1
+}
+
+/*
+	Tracks the state of the map objectives the bots can ask about: every tower's, barracks' and ancient's health (GetTower,
+	GetBarracks, GetAncient), when Roshan was last killed (GetRoshanKillTime) and which rune spots have a rune (GetRuneStatus).
+*/
+type Objectives struct {
+	buildings map[int32][2]int // team (Radiant, Dire) and slot by entindex
+	slots     [2][]int32       // entindex of every team's buildings by slot, 0 when destroyed or not seen yet
+	roshan    int32            // entindex of Roshan while he's alive, 0 otherwise
+	killed    *Moment          // when Roshan was last killed, nil if he hasn't been yet
+	runes     map[int32]bool   // entindexes of the runes on the map
+}
+
+func NewObjectives() *Objectives {
+	objectives := &Objectives{buildings: make(map[int32][2]int), runes: make(map[int32]bool)}
+
+	for team := range objectives.slots {
+		objectives.slots[team] = make([]int32, len(TOWER_NAMES)+len(BARRACKS_NAMES)+1)
+	}
+
+	return objectives
+}
+
+/* Keeps track of buildings, Roshan and runes being created, killed or deleted. now is only called when Roshan dies. */
+func (objectives *Objectives) Update(parser *manta.Parser, ent *manta.Entity, op manta.EntityOp, now func() Moment) {
+	lifeState, _ := ent.GetInt32("m_lifeState")
+	gone := op.Flag(manta.EntityOpDeleted) || lifeState != 0
+
+	switch classname := ent.GetClassName(); classname {
+	case TOWER, BARRACKS, ANCIENT:
+		building, ok := objectives.buildings[ent.GetIndex()]
+
+		if !ok && !gone {
+			team, _ := ent.GetUint64("m_iTeamNum")
+
+			if slot := buildingSlot(classname, GetHammerName(parser, ent)); slot >= 0 && (team == 2 || team == 3) {
+				building = [2]int{int(team) - 2, slot}
+				objectives.buildings[ent.GetIndex()] = building
+				objectives.slots[building[0]][building[1]] = ent.GetIndex()
+			}
+		} else if ok && gone {
+			objectives.slots[building[0]][building[1]] = 0
+			delete(objectives.buildings, ent.GetIndex())
+		}
+	case ROSHAN:
+		if !gone {
+			objectives.roshan = ent.GetIndex()
+		} else if objectives.roshan == ent.GetIndex() {
+			moment := now()
+			objectives.roshan, objectives.killed = 0, &moment
+		}
+	case RUNE:
+		if gone {
+			delete(objectives.runes, ent.GetIndex())
+		} else {
+			objectives.runes[ent.GetIndex()] = true
+		}
+	}
+}
+
+/* Health of every building of a team (allied or enemy, from team's point of view) over its max health, 0 once destroyed. */
+func (objectives *Objectives) buildingHealth(parser *manta.Parser, team uint64) []float32 {
+	health := make([]float32, len(objectives.slots[0]))
+
+	if team != 2 && team != 3 {
+		return health
+	}
+
+	for slot, entindex := range objectives.slots[team-2] {
+		if ent := parser.FindEntity(entindex); entindex != 0 && ent != nil {
+			hp, _ := ent.GetInt32("m_iHealth")
+			maxHP, _ := ent.GetInt32("m_iMaxHealth")
+
+			if maxHP > 0 {
+				health[slot] = float32(hp) / float32(maxHP)
+			}
+		}
+	}
+
+	return health
+}
+
+/* Seconds since Roshan was last killed, 0 if he hasn't been. */
+func (objectives *Objectives) sinceKill(now Moment) float32 {
+	if objectives.killed == nil {
+		return 0
+	} else if now.HasClock && objectives.killed.HasClock {
+		return now.Clock - objectives.killed.Clock
+	}
+
+	return float32(now.Tick-objectives.killed.Tick) / TICKRATE
+}
+
+/*
+	The runes team can see right now: whether there's a power rune in the top and in the bottom half of the river, and how many
+	bounty runes there are. Like GetRuneStatus, runes the team can't see don't count.
+*/
+func (objectives *Objectives) visibleRunes(parser *manta.Parser, team uint64) (bool, bool, int) {
+	top, bottom, bounties := false, false, 0
+
+	for entindex := range objectives.runes {
+		ent := parser.FindEntity(entindex)
+
+		if ent == nil || !IsVisible(ent, team) {
+			continue
+		}
+
+		if runeType, _ := ent.GetInt32("m_iRuneType"); runeType == RUNE_BOUNTY {
+			bounties++
+		} else if coords := GetLocation(ent); coords[1] > coords[0] { // the river runs from the top left to the bottom right
+			top = true
+		} else {
+			bottom = true
+		}
+	}
+
+	return top, bottom, bounties
+}
+
+/* Feature names of the objectives feature group. */
+func objectiveFeatures() []string {
+	features := []string{}
+
+	for _, side := range []string{"allied", "enemy"} {
+		for _, name := range TOWER_NAMES {
+			features = append(features, fmt.Sprintf("health / max health of the %s %s, 0 once destroyed (GetTower)", side, name))
+		}
+
+		for _, name := range BARRACKS_NAMES {
+			features = append(features, fmt.Sprintf("health / max health of the %s %s, 0 once destroyed (GetBarracks)", side, name))
+		}
+
+		features = append(features, fmt.Sprintf("health / max health of the %s ancient (GetAncient)", side))
+	}
+
+	return append(features,
+		"1 if Roshan is alive, else 0",
+		"seconds since Roshan was last killed / 660, 0 if he hasn't been (GetRoshanKillTime)",
+		"1 if Roshan may have respawned (8 to 11 minutes after the kill), else 0",
+		"1 if the top power rune spot has a rune, else 0 (GetRuneStatus)",
+		"1 if the bottom power rune spot has a rune, else 0 (GetRuneStatus)",
+		"bounty runes on the map / 4 (GetRuneStatus)",
+	)
+}
+
+/* The objectives feature group, allied buildings first, as the hero's team knows them. */
+func ObjectiveFeatures(context *FeatureContext) []float32 {
+	objectives := context.Objectives
+
+	if objectives == nil {
+		return make([]float32, len(objectiveFeatures()))
+	}
+
+	features := append(objectives.buildingHealth(context.Parser, context.Team), objectives.buildingHealth(context.Parser, 5-context.Team)...)
+	features = append(features, objectives.roshanFeatures(context.Now)...)
+	top, bottom, bounties := objectives.visibleRunes(context.Parser, context.Team)
+
+	return append(features, boolFeature(top), boolFeature(bottom), float32(bounties)/4)
+}
+
+/* Whether Roshan is alive, how long ago he was killed and whether he may have respawned, as objective features. */
+func (objectives *Objectives) roshanFeatures(now Moment) []float32 {
+	alive, since, window := float32(0), objectives.sinceKill(now), float32(0)
+
+	if objectives.roshan != 0 {
+		alive = 1
+	} else if objectives.killed != nil && since >= ROSHAN_RESPAWN_MIN {
+		window = 1
+	}
+
+	return []float32{alive, since / ROSHAN_RESPAWN_MAX, window}
+}
+
+func boolFeature(value bool) float32 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
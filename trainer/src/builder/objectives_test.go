@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBuildingSlot(t *testing.T) {
+	expected := map[[2]string]int{
+		{TOWER, "dota_goodguys_tower1_top"}:           0,
+		{TOWER, "dota_badguys_tower3_mid"}:            5,
+		{TOWER, "dota_goodguys_tower4_bot"}:           10,
+		{BARRACKS, "good_rax_melee_top"}:              11,
+		{BARRACKS, "bad_rax_range_bot"}:               16,
+		{ANCIENT, "dota_goodguys_fort"}:               17,
+		{TOWER, "dota_goodguys_tower5"}:               -1, // not one the bots can ask about
+		{LANE_CREEP, "npc_dota_creep_goodguys_melee"}: -1,
+	}
+
+	for building, slot := range expected {
+		if found := buildingSlot(building[0], building[1]); found != slot {
+			t.Errorf("%s %s is in slot %d, expected %d", building[0], building[1], found, slot)
+		}
+	}
+}
+
+func TestObjectivesRoshan(t *testing.T) {
+	objectives := NewObjectives()
+
+	if features := fmt.Sprint(objectives.roshanFeatures(Moment{Tick: 1000})); features != "[0 0 0]" {
+		t.Errorf("before Roshan spawned: %s", features)
+	}
+
+	objectives.roshan = 42
+
+	if features := fmt.Sprint(objectives.roshanFeatures(Moment{Tick: 2000})); features != "[1 0 0]" {
+		t.Errorf("while Roshan's alive: %s", features)
+	}
+
+	// Killed at 20:00 on the clock, which then stops for a minute long pause
+	objectives.roshan, objectives.killed = 0, &Moment{Tick: 36000, Clock: 1200, HasClock: true}
+	paused := Moment{Tick: 36000 + 60*TICKRATE, Clock: 1200, HasClock: true}
+
+	if since := objectives.sinceKill(paused); since != 0 {
+		t.Errorf("%f seconds since the kill counted during a pause", since)
+	}
+
+	if features := objectives.roshanFeatures(Moment{Tick: 60000, Clock: 1200 + 330, HasClock: true}); features[1] != 0.5 || features[2] != 0 {
+		t.Errorf("5:30 after the kill: %v", features)
+	}
+
+	if features := objectives.roshanFeatures(Moment{Tick: 60000, Clock: 1200 + ROSHAN_RESPAWN_MIN, HasClock: true}); features[2] != 1 {
+		t.Errorf("8 minutes after the kill: %v", features)
+	}
+
+	// Without a clock, time since the kill is measured in ticks
+	objectives.killed = &Moment{Tick: 36000}
+
+	if since := objectives.sinceKill(Moment{Tick: 36000 + 90*TICKRATE}); since != 90 {
+		t.Errorf("%f seconds since the kill, expected 90", since)
+	}
+}
+
+func TestObjectiveFeatureLayout(t *testing.T) {
+	features := objectiveFeatures()
+
+	if len(features) != 2*(len(TOWER_NAMES)+len(BARRACKS_NAMES)+1)+6 {
+		t.Errorf("%d objective features", len(features))
+	}
+
+	// Before anything's known, every feature is there and 0
+	if values := ObjectiveFeatures(&FeatureContext{}); len(values) != len(features) {
+		t.Errorf("%d objective values for %d features", len(values), len(features))
+	}
+}
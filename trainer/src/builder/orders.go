@@ -0,0 +1,168 @@
+package builder
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta/dota"
+)
+
+/* Actions, one per bot API call the bots would make to give an order. */
+const (
+	ActionOther = iota + 1 // orders the bots can't give
+	ActionMoveToLocation
+	ActionMoveToUnit
+	ActionAttackMove
+	ActionAttackUnit
+	ActionUseAbility
+	ActionUseAbilityOnLocation
+	ActionUseAbilityOnEntity
+	ActionUseAbilityOnTree
+	ActionToggleAutoCast
+	ActionClearActions
+	ActionLevelAbility
+	ActionPickUpItem
+	ActionPickUpRune
+	ActionDropItem
+	ActionPurchaseItem
+	ActionSellItem
+	ActionDisassembleItem
+	ActionSwapItems
+	ActionSetItemCombineLock
+	ActionBuyback
+	ActionGlyph
+	ActionPing
+)
+
+/* The bot API call behind every action, written to ability_data.lua as the actions table. */
+var ACTION_CALLS = map[int]string{
+	ActionOther:                "",
+	ActionMoveToLocation:       "Action_MoveToLocation",
+	ActionMoveToUnit:           "Action_MoveToUnit",
+	ActionAttackMove:           "Action_AttackMove",
+	ActionAttackUnit:           "Action_AttackUnit",
+	ActionUseAbility:           "Action_UseAbility",
+	ActionUseAbilityOnLocation: "Action_UseAbilityOnLocation",
+	ActionUseAbilityOnEntity:   "Action_UseAbilityOnEntity",
+	ActionUseAbilityOnTree:     "Action_UseAbilityOnTree",
+	ActionToggleAutoCast:       "ToggleAutoCast",
+	ActionClearActions:         "Action_ClearActions",
+	ActionLevelAbility:         "ActionImmediate_LevelAbility",
+	ActionPickUpItem:           "Action_PickUpItem",
+	ActionPickUpRune:           "Action_PickUpRune",
+	ActionDropItem:             "Action_DropItem",
+	ActionPurchaseItem:         "ActionImmediate_PurchaseItem",
+	ActionSellItem:             "ActionImmediate_SellItem",
+	ActionDisassembleItem:      "ActionImmediate_DisassembleItem",
+	ActionSwapItems:            "ActionImmediate_SwapItems",
+	ActionSetItemCombineLock:   "ActionImmediate_SetItemCombineLock",
+	ActionBuyback:              "ActionImmediate_Buyback",
+	ActionGlyph:                "ActionImmediate_Glyph",
+	ActionPing:                 "ActionImmediate_Ping",
+}
+
+/* The action behind every order type the bots can give. */
+var ORDER_ACTIONS = map[dota.DotaunitorderT]int{
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_MOVE_TO_POSITION:       ActionMoveToLocation,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_MOVE_TO_DIRECTION:      ActionMoveToLocation,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_MOVE_RELATIVE:          ActionMoveToLocation,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_MOVE_TO_TARGET:         ActionMoveToUnit,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_ATTACK_MOVE:            ActionAttackMove,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_ATTACK_TARGET:          ActionAttackUnit,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_NO_TARGET:         ActionUseAbility,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_TOGGLE:            ActionUseAbility,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_TOGGLE_ALT:        ActionUseAbility,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_POSITION:          ActionUseAbilityOnLocation,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_VECTOR_TARGET_POSITION: ActionUseAbilityOnLocation,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_TARGET:            ActionUseAbilityOnEntity,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_TARGET_TREE:       ActionUseAbilityOnTree,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_TOGGLE_AUTO:       ActionToggleAutoCast,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_HOLD_POSITION:          ActionClearActions,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_STOP:                   ActionClearActions,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_TRAIN_ABILITY:          ActionLevelAbility,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_PICKUP_ITEM:            ActionPickUpItem,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_PICKUP_RUNE:            ActionPickUpRune,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_DROP_ITEM:              ActionDropItem,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_DROP_ITEM_AT_FOUNTAIN:  ActionDropItem,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_PURCHASE_ITEM:          ActionPurchaseItem,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_SELL_ITEM:              ActionSellItem,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_DISASSEMBLE_ITEM:       ActionDisassembleItem,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_MOVE_ITEM:              ActionSwapItems,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_SET_ITEM_COMBINE_LOCK:  ActionSetItemCombineLock,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_BUYBACK:                ActionBuyback,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_GLYPH:                  ActionGlyph,
+	dota.DotaunitorderT_DOTA_UNIT_ORDER_PING_ABILITY:           ActionPing,
+}
+
+/* The action the bots would take to give an order, ActionOther if they can't give it. */
+func OrderAction(orderType int32) int {
+	if action, ok := ORDER_ACTIONS[dota.DotaunitorderT(orderType)]; ok {
+		return action
+	}
+
+	return ActionOther
+}
+
+/* A move example waiting for the orders queued after it. */
+type orderChain struct {
+	corpus  *Corpus
+	example *MoveExample
+}
+
+/*
+	Groups chains of shift-queued orders into one move example when enabled: every player's last unqueued order waits for the
+	orders queued after it, which go in its Sequence, like the bots would give them with ActionQueue_* after an Action_* call.
+	Otherwise every order is written as is.
+*/
+type orderChains struct {
+	enabled bool
+	pending map[int32]*orderChain // by player ID
+}
+
+func newOrderChains(enabled bool) *orderChains {
+	return &orderChains{enabled, make(map[int32]*orderChain)}
+}
+
+/* Adds a player's move example, writing whatever it ends. */
+func (chains *orderChains) Add(playerID int32, corpus *Corpus, example *MoveExample, queued bool) error {
+	if !chains.enabled {
+		return WriteToCorpus(example, corpus.Move)
+	}
+
+	if chain, ok := chains.pending[playerID]; ok && queued && chain.corpus == corpus {
+		chain.example.Sequence = append(chain.example.Sequence, example.MoveOutputExample)
+		return nil
+	}
+
+	err := chains.flush(playerID)
+	chains.pending[playerID] = &orderChain{corpus, example}
+
+	return err
+}
+
+func (chains *orderChains) flush(playerID int32) error {
+	if chain, ok := chains.pending[playerID]; ok {
+		delete(chains.pending, playerID)
+		return WriteToCorpus(chain.example, chain.corpus.Move)
+	}
+
+	return nil
+}
+
+/* Writes every chain still waiting, in player ID order. */
+func (chains *orderChains) Flush() error {
+	players := make([]int, 0, len(chains.pending))
+
+	for playerID := range chains.pending {
+		players = append(players, int(playerID))
+	}
+
+	sort.Ints(players)
+
+	for _, playerID := range players {
+		if err := chains.flush(int32(playerID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
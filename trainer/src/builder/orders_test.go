@@ -0,0 +1,201 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dotabuff/manta/dota"
+)
+
+func TestOrderAction(t *testing.T) {
+	expected := map[dota.DotaunitorderT]int{
+		dota.DotaunitorderT_DOTA_UNIT_ORDER_MOVE_TO_POSITION: ActionMoveToLocation,
+		dota.DotaunitorderT_DOTA_UNIT_ORDER_ATTACK_TARGET:    ActionAttackUnit,
+		dota.DotaunitorderT_DOTA_UNIT_ORDER_CAST_TARGET:      ActionUseAbilityOnEntity,
+		dota.DotaunitorderT_DOTA_UNIT_ORDER_STOP:             ActionClearActions,
+		dota.DotaunitorderT_DOTA_UNIT_ORDER_TAUNT:            ActionOther,
+		dota.DotaunitorderT_DOTA_UNIT_ORDER_NONE:             ActionOther,
+	}
+
+	for order, action := range expected {
+		if OrderAction(int32(order)) != action {
+			t.Errorf("order type %d gives action %d, expected %d", order, OrderAction(int32(order)), action)
+		}
+	}
+
+	if OrderAction(-1) != ActionOther {
+		t.Errorf("unknown order type gives action %d", OrderAction(-1))
+	}
+
+	for order, action := range ORDER_ACTIONS {
+		if _, ok := ACTION_CALLS[action]; !ok {
+			t.Errorf("order type %d gives action %d, which has no bot API call", order, action)
+		}
+	}
+}
+
+/* A corpus whose move examples go to a buffer. */
+func testCorpus() (*Corpus, *bytes.Buffer) {
+	buffer := new(bytes.Buffer)
+	return &Corpus{Move: bufio.NewWriter(buffer)}, buffer
+}
+
+/* The move examples written to a testCorpus. */
+func writtenExamples(t *testing.T, corpus *Corpus, buffer *bytes.Buffer) []*MoveExample {
+	if err := corpus.Move.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	examples := []*MoveExample{}
+
+	if err := json.Unmarshal([]byte("["+strings.TrimSuffix(buffer.String(), ",")+"]"), &examples); err != nil {
+		t.Fatal(err)
+	}
+
+	return examples
+}
+
+/* A move example told apart by when it was given, which is also where it moves to so it can be told apart in a Sequence. */
+func testOrder(time float32, queued bool) *MoveExample {
+	example := &MoveExample{}
+	example.DotaTime = time
+	example.MoveX = time
+	example.Action = ActionMoveToLocation
+
+	if queued {
+		example.Queued = 1
+	}
+
+	return example
+}
+
+/* Adds the orders (player ID, time, queued) to chains. */
+func addOrders(t *testing.T, chains *orderChains, corpora map[int32]*Corpus, orders [][3]float32) {
+	for _, order := range orders {
+		playerID := int32(order[0])
+
+		if err := chains.Add(playerID, corpora[playerID], testOrder(order[1], order[2] == 1), order[2] == 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+/* DotaTime of every written example, followed by the MoveX of every order in its Sequence. */
+func describeExamples(examples []*MoveExample) [][]float32 {
+	described := [][]float32{}
+
+	for _, example := range examples {
+		description := []float32{example.DotaTime}
+
+		for _, order := range example.Sequence {
+			if order.Queued != 1 {
+				description = append(description, -1) // never queued, so never in a Sequence
+			}
+
+			description = append(description, order.MoveX)
+		}
+
+		described = append(described, description)
+	}
+
+	return described
+}
+
+func TestOrderChains(t *testing.T) {
+	corpus, buffer := testCorpus()
+	corpora := map[int32]*Corpus{1: corpus, 2: corpus}
+	chains := newOrderChains(true)
+
+	addOrders(t, chains, corpora, [][3]float32{
+		{1, 1, 0}, // starts a chain
+		{1, 2, 1},
+		{2, 3, 0}, // another player's chain
+		{1, 4, 1},
+		{2, 5, 1},
+		{1, 6, 0}, // ends player 1's first chain
+		{1, 7, 1},
+	})
+
+	if written := writtenExamples(t, corpus, buffer); len(written) != 1 || len(written[0].Sequence) != 2 {
+		t.Fatalf("expected player 1's first chain of three orders to be written, got %v", describeExamples(written))
+	}
+
+	if err := chains.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[[1 2 4] [6 7] [3 5]]"
+
+	if written := describeExamples(writtenExamples(t, corpus, buffer)); fmt.Sprint(written) != expected {
+		t.Errorf("wrote %s, expected %s", fmt.Sprint(written), expected)
+	}
+
+	if len(chains.pending) != 0 {
+		t.Errorf("%d chains still pending after Flush", len(chains.pending))
+	}
+}
+
+func TestOrderChainsEndOnUnrecordedOrders(t *testing.T) {
+	corpus, buffer := testCorpus()
+	chains := newOrderChains(true)
+
+	addOrders(t, chains, map[int32]*Corpus{1: corpus}, [][3]float32{{1, 1, 0}, {1, 2, 1}})
+
+	// An unqueued order that isn't recorded (say, to a courier) ends the chain, so what's queued after it isn't part of it
+	if err := chains.flush(1); err != nil {
+		t.Fatal(err)
+	}
+
+	addOrders(t, chains, map[int32]*Corpus{1: corpus}, [][3]float32{{1, 3, 1}})
+
+	if err := chains.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[[1 2] [3]]"
+
+	if written := describeExamples(writtenExamples(t, corpus, buffer)); fmt.Sprint(written) != expected {
+		t.Errorf("wrote %s, expected %s", fmt.Sprint(written), expected)
+	}
+}
+
+func TestOrderChainsSplitByCorpus(t *testing.T) {
+	radiant, radiantBuffer := testCorpus()
+	dire, direBuffer := testCorpus()
+	chains := newOrderChains(true)
+
+	// The same player ID on another corpus (such as after a hero swap) never continues the chain
+	addOrders(t, chains, map[int32]*Corpus{1: radiant}, [][3]float32{{1, 1, 0}})
+	addOrders(t, chains, map[int32]*Corpus{1: dire}, [][3]float32{{1, 2, 1}})
+
+	if err := chains.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if written := describeExamples(writtenExamples(t, radiant, radiantBuffer)); fmt.Sprint(written) != "[[1]]" {
+		t.Errorf("wrote %s to the first corpus", fmt.Sprint(written))
+	}
+
+	if written := describeExamples(writtenExamples(t, dire, direBuffer)); fmt.Sprint(written) != "[[2]]" {
+		t.Errorf("wrote %s to the second corpus", fmt.Sprint(written))
+	}
+}
+
+func TestOrderChainsDisabled(t *testing.T) {
+	corpus, buffer := testCorpus()
+	chains := newOrderChains(false)
+
+	addOrders(t, chains, map[int32]*Corpus{1: corpus}, [][3]float32{{1, 1, 0}, {1, 2, 1}, {1, 3, 1}})
+
+	if written := writtenExamples(t, corpus, buffer); len(written) != 3 {
+		t.Errorf("expected every order to be written as is, got %v", describeExamples(written))
+	}
+
+	if len(chains.pending) != 0 {
+		t.Errorf("%d chains pending while grouping is off", len(chains.pending))
+	}
+}
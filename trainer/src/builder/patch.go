@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* The first server build of a patch. */
+type PatchStart struct {
+	Build uint32
+	Patch string
+}
+
+/*
+	Maps server builds to patch names (7.33c and so on), which replays don't record. Sorted by build; every build belongs to the
+	latest patch that started at or before it.
+*/
+type PatchTable []PatchStart
+
+/*
+	Reads a patch table file. Every line is the first build of a patch and its name, such as "5876 7.33c". Blank lines and lines
+	starting with # are skipped.
+*/
+func LoadPatches(path string) (PatchTable, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	lines, err := ReadList(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(PatchTable, 0, len(lines))
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: expected <build> <patch>, found %q", path, line)
+		}
+
+		build, err := strconv.ParseUint(fields[0], 10, 32)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad build %q", path, fields[0])
+		}
+
+		table = append(table, PatchStart{uint32(build), fields[1]})
+	}
+
+	sort.SliceStable(table, func(i, j int) bool {
+		return table[i].Build < table[j].Build
+	})
+
+	return table, nil
+}
+
+/* The patch a build belongs to, or "" if the table doesn't go back that far. */
+func (table PatchTable) Lookup(build uint32) string {
+	i := sort.Search(len(table), func(i int) bool {
+		return table[i].Build > build
+	})
+
+	if i == 0 {
+		return ""
+	}
+
+	return table[i-1].Patch
+}
+
+/* Folder of a patch's corpora in an output folder. Corpora that aren't split by patch ("") are the output folder itself. */
+func PatchRoot(root string, patch string) string {
+	if patch == "" {
+		return root
+	}
+
+	return filepath.Join(root, patch)
+}
+
+/* Names the patch corpora are from, for messages. */
+func describePatch(patch string) string {
+	if patch == "" {
+		return "unknown patches"
+	}
+
+	return "patch " + patch
+}
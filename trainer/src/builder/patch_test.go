@@ -0,0 +1,91 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* Writes a patch table file, returning its path. */
+func writeTestPatches(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "patches")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+func TestLoadPatches(t *testing.T) {
+	path := writeTestPatches(t, "# build patch\n5876 7.33c\n\n5500 7.33\n6000 7.34\n")
+	defer os.Remove(path)
+
+	table, err := LoadPatches(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := PatchTable{{5500, "7.33"}, {5876, "7.33c"}, {6000, "7.34"}}
+
+	if len(table) != len(expected) {
+		t.Fatalf("loaded %v, expected %v", table, expected)
+	}
+
+	for i := range expected {
+		if table[i] != expected[i] {
+			t.Errorf("loaded %v, expected %v", table, expected)
+		}
+	}
+
+	for _, contents := range []string{"5876\n", "5876 7.33c extra\n", "build 7.33c\n"} {
+		path := writeTestPatches(t, contents)
+
+		if _, err := LoadPatches(path); err == nil {
+			t.Errorf("expected an error loading %q", contents)
+		}
+
+		os.Remove(path)
+	}
+}
+
+func TestPatchTableLookup(t *testing.T) {
+	table := PatchTable{{5500, "7.33"}, {5876, "7.33c"}, {6000, "7.34"}}
+
+	expected := map[uint32]string{
+		5499: "", // older than the table
+		5500: "7.33",
+		5875: "7.33",
+		5876: "7.33c",
+		6000: "7.34",
+		9999: "7.34",
+	}
+
+	for build, patch := range expected {
+		if found := table.Lookup(build); found != patch {
+			t.Errorf("build %d is in %q, expected %q", build, found, patch)
+		}
+	}
+
+	if found := (PatchTable{}).Lookup(5876); found != "" {
+		t.Errorf("empty table found %q", found)
+	}
+}
+
+func TestPatchRoot(t *testing.T) {
+	if root := PatchRoot("out", ""); root != "out" {
+		t.Errorf("corpora not split by patch go to %s", root)
+	}
+
+	if root := PatchRoot("out", "7.33c"); root != filepath.Join("out", "7.33c") {
+		t.Errorf("7.33c corpora go to %s", root)
+	}
+}
@@ -0,0 +1,129 @@
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+	Strips the enclosing brackets and separating commas from a corpus file so a json.Decoder can read its examples one at a time.
+
+	Corpus files are written as "[\n" followed by every example and a trailing comma, then "\n]" on close, which isn't quite JSON
+	(and can be far too big to decode in one go anyway).
+*/
+type exampleStream struct {
+	reader   *bufio.Reader
+	depth    int
+	inString bool
+	escaped  bool
+	opened   bool
+}
+
+func (stream *exampleStream) Read(p []byte) (int, error) {
+	n, err := stream.reader.Read(p)
+
+	for i := 0; i < n; i++ {
+		c := p[i]
+
+		if stream.inString {
+			if stream.escaped {
+				stream.escaped = false
+			} else if c == '\\' {
+				stream.escaped = true
+			} else if c == '"' {
+				stream.inString = false
+			}
+
+			continue
+		}
+
+		switch c {
+		case '"':
+			stream.inString = true
+		case '[', '{':
+			if stream.depth == 0 {
+				if stream.opened || c != '[' {
+					return i, errors.New("corpus doesn't start with '['")
+				}
+
+				stream.opened = true
+				p[i] = ' '
+			}
+
+			stream.depth++
+		case ']', '}':
+			stream.depth--
+
+			if stream.depth == 0 {
+				p[i] = ' '
+			} else if stream.depth < 0 {
+				return i, errors.New("unbalanced brackets")
+			}
+		case ',':
+			if stream.depth == 1 {
+				p[i] = ' '
+			}
+		}
+	}
+
+	return n, err
+}
+
+/* Calls fn with every example in a corpus file, in order. */
+func ReadCorpus(path string, fn func(json.RawMessage) error) error {
+	return ReadCorpusWith(path, nil, fn)
+}
+
+/* ReadCorpus, but the corpus file is opened with open, if given. */
+func ReadCorpusWith(path string, open func(path string) (io.ReadCloser, error), fn func(json.RawMessage) error) error {
+	if open == nil {
+		open = func(path string) (io.ReadCloser, error) { return os.Open(path) }
+	}
+
+	file, err := open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	stream := &exampleStream{reader: bufio.NewReader(file)}
+	decoder := json.NewDecoder(stream)
+
+	for {
+		var example json.RawMessage
+
+		if err := decoder.Decode(&example); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		if err := fn(example); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if !stream.opened || stream.depth != 0 {
+		return fmt.Errorf("%s: corpus was never closed (missing ']')", path)
+	}
+
+	return nil
+}
+
+/* Counts the examples in a corpus file. */
+func CountExamples(path string) (int, error) {
+	count := 0
+
+	err := ReadCorpus(path, func(json.RawMessage) error {
+		count++
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,277 @@
+package builder
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+/* Player selection policies. */
+const (
+	PlayersTopKills    = "top-kills"
+	PlayersTopKDA      = "top-kda"
+	PlayersTopNetWorth = "top-networth"
+	PlayersTopGPM      = "top-gpm"
+	PlayersWinners     = "winners"
+	PlayersAll         = "all"
+	PlayersAccounts    = "accounts"
+)
+
+var SELECTION_POLICIES = []string{PlayersTopKills, PlayersTopKDA, PlayersTopNetWorth, PlayersTopGPM, PlayersWinners, PlayersAll, PlayersAccounts}
+
+/* Difference between 64 bit Steam IDs and the 32 bit account IDs Dota shows. */
+const STEAM_ID_BASE = 76561197960265728
+
+/* Decides, once the game is over, which players' actions become examples. */
+type PlayerSelector interface {
+	Select(players []*TopPlayer, winningTeam int32) []*TopPlayer
+}
+
+/* Keeps the K players on the winning team with the highest score. */
+type TopKSelector struct {
+	K     int
+	Score func(*TopPlayer) float64
+}
+
+func (selector *TopKSelector) Select(players []*TopPlayer, winningTeam int32) []*TopPlayer {
+	winners := (&WinnersSelector{}).Select(players, winningTeam)
+
+	sort.SliceStable(winners, func(i, j int) bool { // ties go to the lower player ID
+		return selector.Score(winners[i]) > selector.Score(winners[j])
+	})
+
+	if len(winners) > selector.K {
+		winners = winners[:selector.K]
+	}
+
+	return winners
+}
+
+/* Keeps everyone on the winning team. */
+type WinnersSelector struct{}
+
+func (selector *WinnersSelector) Select(players []*TopPlayer, winningTeam int32) []*TopPlayer {
+	var winners []*TopPlayer
+
+	for _, player := range players {
+		if player.Team == winningTeam {
+			winners = append(winners, player)
+		}
+	}
+
+	return winners
+}
+
+/* Keeps all ten players, winners or not. */
+type AllSelector struct{}
+
+func (selector *AllSelector) Select(players []*TopPlayer, winningTeam int32) []*TopPlayer {
+	return players
+}
+
+/* Keeps the players whose Steam accounts are listed, on either team. */
+type AccountSelector struct {
+	Accounts map[uint32]bool
+}
+
+func (selector *AccountSelector) Select(players []*TopPlayer, winningTeam int32) []*TopPlayer {
+	var listed []*TopPlayer
+
+	for _, player := range players {
+		if selector.Accounts[player.AccountID()] {
+			listed = append(listed, player)
+		}
+	}
+
+	return listed
+}
+
+/* Hides denied accounts, and accounts missing from the allowlist if there is one, from another selector. */
+type AccountFilter struct {
+	PlayerSelector
+	Allow map[uint32]bool
+	Deny  map[uint32]bool
+}
+
+func NewAccountFilter(selector PlayerSelector, allow []uint32, deny []uint32) *AccountFilter {
+	filter := &AccountFilter{selector, make(map[uint32]bool), make(map[uint32]bool)}
+
+	for _, account := range allow {
+		filter.Allow[account] = true
+	}
+
+	for _, account := range deny {
+		filter.Deny[account] = true
+	}
+
+	return filter
+}
+
+func (filter *AccountFilter) Select(players []*TopPlayer, winningTeam int32) []*TopPlayer {
+	var kept []*TopPlayer
+
+	for _, player := range players {
+		account := player.AccountID()
+
+		if !filter.Deny[account] && (len(filter.Allow) == 0 || filter.Allow[account]) {
+			kept = append(kept, player)
+		}
+	}
+
+	return filter.PlayerSelector.Select(kept, winningTeam)
+}
+
+/* Scores for the top-K policies. */
+func ByKills(player *TopPlayer) float64 {
+	return float64(player.Kills)
+}
+
+func ByKDA(player *TopPlayer) float64 {
+	deaths := player.Deaths
+
+	if deaths < 1 {
+		deaths = 1
+	}
+
+	return float64(player.Kills+player.Assists) / float64(deaths)
+}
+
+func ByNetWorth(player *TopPlayer) float64 {
+	return float64(player.NetWorth)
+}
+
+func ByGPM(player *TopPlayer) float64 {
+	return float64(player.GPM)
+}
+
+/* Creates the selector for a policy. k is only used by the top-K policies and accounts only by the accounts policy. */
+func NewSelector(policy string, k int, accounts []uint32) (PlayerSelector, error) {
+	switch policy {
+	case "", PlayersTopKills:
+		return &TopKSelector{k, ByKills}, nil
+	case PlayersTopKDA:
+		return &TopKSelector{k, ByKDA}, nil
+	case PlayersTopNetWorth:
+		return &TopKSelector{k, ByNetWorth}, nil
+	case PlayersTopGPM:
+		return &TopKSelector{k, ByGPM}, nil
+	case PlayersWinners:
+		return &WinnersSelector{}, nil
+	case PlayersAll:
+		return &AllSelector{}, nil
+	case PlayersAccounts:
+		selector := &AccountSelector{make(map[uint32]bool)}
+
+		for _, account := range accounts {
+			selector.Accounts[account] = true
+		}
+
+		return selector, nil
+	}
+
+	return nil, fmt.Errorf("unknown player selection policy %q", policy)
+}
+
+/* Parses a Steam account given either as an account ID or as a 64 bit Steam ID. */
+func ParseAccount(account string) (uint32, error) {
+	id, err := strconv.ParseUint(strings.TrimSpace(account), 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("bad Steam account %q", account)
+	}
+
+	if id >= STEAM_ID_BASE {
+		id -= STEAM_ID_BASE
+	}
+
+	if id > math.MaxUint32 {
+		return 0, fmt.Errorf("bad Steam account %q", account)
+	}
+
+	return uint32(id), nil
+}
+
+/* Reads a file of Steam accounts, one per line. Anything after the account on a line (such as the player's name) is ignored. */
+func ReadAccounts(path string) ([]uint32, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	lines, err := ReadList(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]uint32, len(lines))
+
+	for i, line := range lines {
+		if accounts[i], err = ParseAccount(strings.Fields(line)[0]); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	return accounts, nil
+}
+
+/* The 32 bit account ID behind a 64 bit Steam ID, 0 for bots. */
+func AccountOf(steamID uint64) uint32 {
+	if steamID < STEAM_ID_BASE {
+		return 0
+	}
+
+	return uint32(steamID - STEAM_ID_BASE)
+}
+
+func (player *TopPlayer) AccountID() uint32 {
+	return AccountOf(player.SteamID)
+}
+
+/*
+	Reads the end of game stats of all ten players, in player ID order. teamData maps teams to the entindex of their
+	CDOTA_DataRadiant/CDOTA_DataDire, which is where net worth and earned gold are kept.
+*/
+func ReadPlayers(parser *manta.Parser, resource *manta.Entity, teamData map[uint64]int32, startTime uint32) []*TopPlayer {
+	var players []*TopPlayer
+
+	minutes := (float32(parser.Tick) - float32(startTime)) / (TICKRATE * 60)
+
+	for i := int32(0); i < 10; i++ {
+		id := fmt.Sprintf("%04d", i)
+
+		kills, ok := resource.GetInt32("m_vecPlayerTeamData." + id + ".m_iKills")
+
+		if !ok { // empty slot
+			continue
+		}
+
+		player := &TopPlayer{ID: i, Team: 2 + i/5, Kills: kills}
+		player.Name, _ = resource.GetString("m_vecPlayerData." + id + ".m_iszPlayerName")
+		player.SteamID, _ = resource.GetUint64("m_vecPlayerData." + id + ".m_iPlayerSteamID")
+		player.Deaths, _ = resource.GetInt32("m_vecPlayerTeamData." + id + ".m_iDeaths")
+		player.Assists, _ = resource.GetInt32("m_vecPlayerTeamData." + id + ".m_iAssists")
+
+		if teamEnt := parser.FindEntity(teamData[uint64(player.Team)]); teamEnt != nil {
+			slot := fmt.Sprintf("%04d", i%5)
+
+			player.NetWorth, _ = teamEnt.GetInt32("m_vecDataTeam." + slot + ".m_iNetWorth")
+
+			if earned, ok := teamEnt.GetInt32("m_vecDataTeam." + slot + ".m_iTotalEarnedGold"); ok && minutes > 0 {
+				player.GPM = float32(earned) / minutes
+			}
+		}
+
+		players = append(players, player)
+	}
+
+	return players
+}
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"fmt"
+	"testing"
+)
+
+/* Ten players, 0-4 on team 2 and 5-9 on team 3, with account ID 100 + player ID. */
+func testPlayers(kills ...int32) []*TopPlayer {
+	players := make([]*TopPlayer, 10)
+
+	for i := range players {
+		players[i] = &TopPlayer{ID: int32(i), Team: 2 + int32(i/5), SteamID: STEAM_ID_BASE + 100 + uint64(i)}
+
+		if i < len(kills) {
+			players[i].Kills = kills[i]
+		}
+	}
+
+	return players
+}
+
+/* The IDs of the selected players. */
+func selectedIDs(players []*TopPlayer) string {
+	ids := []int32{}
+
+	for _, player := range players {
+		ids = append(ids, player.ID)
+	}
+
+	return fmt.Sprint(ids)
+}
+
+func TestTopKSelector(t *testing.T) {
+	// Player 8 has the most kills but lost, player 1 and 3 tie
+	players := testPlayers(2, 5, 0, 5, 7, 0, 0, 0, 20, 0)
+	selector := &TopKSelector{3, ByKills}
+
+	if selected := selectedIDs(selector.Select(players, 2)); selected != "[4 1 3]" {
+		t.Errorf("selected %s", selected)
+	}
+
+	if selected := selectedIDs((&TopKSelector{10, ByKills}).Select(players, 3)); selected != "[8 5 6 7 9]" {
+		t.Errorf("selected %s when K is above the team size", selected)
+	}
+}
+
+func TestWinnersSelector(t *testing.T) {
+	if selected := selectedIDs((&WinnersSelector{}).Select(testPlayers(), 3)); selected != "[5 6 7 8 9]" {
+		t.Errorf("selected %s", selected)
+	}
+}
+
+func TestNewSelectorRejectsUnknownPolicies(t *testing.T) {
+	for _, policy := range SELECTION_POLICIES {
+		if _, err := NewSelector(policy, 1, nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := NewSelector("top-deaths", 1, nil); err == nil {
+		t.Error("expected an unknown policy to be an error")
+	}
+}
+
+func TestAccountSelectors(t *testing.T) {
+	accounts, err := NewSelector(PlayersAccounts, 0, []uint32{101, 107})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if selected := selectedIDs(accounts.Select(testPlayers(), 2)); selected != "[1 7]" {
+		t.Errorf("account selector picked %s", selected)
+	}
+
+	denied := NewAccountFilter(&AllSelector{}, nil, []uint32{100, 109})
+
+	if selected := selectedIDs(denied.Select(testPlayers(), 2)); selected != "[1 2 3 4 5 6 7 8]" {
+		t.Errorf("denylist kept %s", selected)
+	}
+
+	allowed := NewAccountFilter(&WinnersSelector{}, []uint32{101, 102, 106}, []uint32{102})
+
+	if selected := selectedIDs(allowed.Select(testPlayers(), 2)); selected != "[1]" {
+		t.Errorf("allowlist kept %s", selected)
+	}
+}
+
+func TestParseAccount(t *testing.T) {
+	expected := map[string]uint32{
+		"86745912":           86745912,
+		" 76561198047011640": 86745912,
+		"0":                  0,
+	}
+
+	for account, id := range expected {
+		if parsed, err := ParseAccount(account); err != nil {
+			t.Errorf("%q: %s", account, err)
+		} else if parsed != id {
+			t.Errorf("%q parsed as %d, expected %d", account, parsed, id)
+		}
+	}
+
+	for _, account := range []string{"", "player", "-5", "99999999999"} {
+		if _, err := ParseAccount(account); err == nil {
+			t.Errorf("expected %q to be an error", account)
+		}
+	}
+
+	if AccountOf(42) != 0 {
+		t.Error("expected bots to have no account")
+	}
+}
@@ -0,0 +1,326 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/* Default memory budget for the single pass' player buffers. */
+const SINGLE_PASS_MEMORY = 256 << 20
+
+/*
+	How many bytes of examples single passes keep in memory, shared by all the demos a Builder parses at once so the budget holds
+	however many jobs there are. Player buffers keep their examples in memory until the budget runs out, and then the biggest
+	ones are spilled to their temp files until it holds again.
+*/
+type MemoryBudget struct {
+	limit   int
+	mutex   sync.Mutex
+	used    int
+	buffers map[*spillFile]bool // the ones that can still be spilled
+}
+
+func NewMemoryBudget(limit int) *MemoryBudget {
+	return &MemoryBudget{limit: limit, buffers: make(map[*spillFile]bool)}
+}
+
+/* Spills the biggest buffers until the budget holds again. Called with the mutex held. */
+func (budget *MemoryBudget) spill() error {
+	for budget.used > budget.limit {
+		var biggest *spillFile
+
+		for buffer := range budget.buffers {
+			if biggest == nil || buffer.memory.Len() > biggest.memory.Len() {
+				biggest = buffer
+			}
+		}
+
+		if biggest == nil || biggest.memory.Len() == 0 { // what's left is being merged
+			return nil
+		}
+
+		if err := biggest.spill(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Forgets a buffer's examples, in memory or spilled. */
+func (budget *MemoryBudget) release(buffer *spillFile) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	delete(budget.buffers, buffer)
+	budget.used -= buffer.memory.Len()
+	buffer.memory = bytes.Buffer{}
+
+	os.Remove(buffer.path)
+}
+
+/* A corpus file of a player buffer. Its examples are kept in memory and only written to the file at path when they're spilled. */
+type spillFile struct {
+	budget *MemoryBudget
+	path   string
+	memory bytes.Buffer
+}
+
+func newSpillFile(budget *MemoryBudget, path string) *spillFile {
+	buffer := &spillFile{budget: budget, path: path}
+
+	budget.mutex.Lock()
+	budget.buffers[buffer] = true
+	budget.mutex.Unlock()
+
+	return buffer
+}
+
+func (buffer *spillFile) Write(p []byte) (int, error) {
+	budget := buffer.budget
+
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	buffer.memory.Write(p)
+	budget.used += len(p)
+
+	return len(p), budget.spill()
+}
+
+/* Appends what's in memory to the file. Called with the budget's mutex held. */
+func (buffer *spillFile) spill() error {
+	file, err := os.OpenFile(buffer.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	size := buffer.memory.Len()
+	_, err = buffer.memory.WriteTo(file)
+	buffer.budget.used -= size - buffer.memory.Len()
+
+	return FirstError(err, file.Close())
+}
+
+/* Nothing to do, the examples stay where they are until they're read or released. */
+func (buffer *spillFile) Close() error {
+	return nil
+}
+
+/* Reads the examples back, spilled ones first. The buffer can't be spilled anymore after this, only released. */
+func (buffer *spillFile) Open() (io.ReadCloser, error) {
+	budget := buffer.budget
+
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	delete(budget.buffers, buffer)
+	memory := bytes.NewReader(buffer.memory.Bytes())
+
+	file, err := os.Open(buffer.path)
+
+	if os.IsNotExist(err) {
+		return ioutil.NopCloser(memory), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &stackedReader{io.MultiReader(file, memory), []io.Closer{file}}, nil
+}
+
+/*
+	Buffers the examples of every player in their own scratch corpora, since who gets selected isn't known until the game is
+	over. The corpus files keep their examples in memory, charged to budget, and spill to temp files under root when it runs out.
+
+	Examples are timed in raw gamerules clock seconds, or ticks for replays without a clock (the horn may not even have happened
+	yet), and converted once the start time is known. Likewise, nobody's lane is known until the laning phase is over, so every
+	lane front is kept for the ticks examples were recorded at and filled in at the end.
+*/
+type playerBuffers struct {
+	root    string
+	budget  *MemoryBudget
+	players map[int32]*Corpora
+	files   map[int32]map[string]*spillFile // by player ID and path
+	clocked map[uint32]bool                 // by tick, whether the examples recorded then are timed by the clock
+	fronts  map[uint32][2][4]float32        // lane fronts by tick, see LaneFronts.Snapshot
+}
+
+func newPlayerBuffers(root string, budget *MemoryBudget) *playerBuffers {
+	return &playerBuffers{root, budget, make(map[int32]*Corpora), make(map[int32]map[string]*spillFile), make(map[uint32]bool), make(map[uint32][2][4]float32)}
+}
+
+func (buffers *playerBuffers) Corpus(playerID int32, hero string, team uint64) (*Corpus, error) {
+	corpora, ok := buffers.players[playerID]
+
+	if !ok {
+		root := filepath.Join(buffers.root, strconv.Itoa(int(playerID)))
+
+		if err := os.MkdirAll(root, 493); err != nil {
+			return nil, err
+		}
+
+		files := make(map[string]*spillFile)
+		buffers.files[playerID] = files
+
+		corpora = NewCorpora(root)
+		corpora.Create = func(path string) (io.WriteCloser, error) {
+			files[path] = newSpillFile(buffers.budget, path)
+			return files[path], nil
+		}
+
+		buffers.players[playerID] = corpora
+	}
+
+	teams, err := corpora.GetCorpus(hero)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return teams[team-2], nil
+}
+
+/* Opens a corpus file of a player's corpora for merging, see spillFile.Open. */
+func (buffers *playerBuffers) open(playerID int32) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		if buffer, ok := buffers.files[playerID][path]; ok {
+			return buffer.Open()
+		}
+
+		return os.Open(path)
+	}
+}
+
+/* Gives a player's share of the memory budget back. */
+func (buffers *playerBuffers) Release(playerID int32) {
+	for _, buffer := range buffers.files[playerID] {
+		buffers.budget.release(buffer)
+	}
+
+	delete(buffers.files, playerID)
+}
+
+func (buffers *playerBuffers) Time(now Moment) float32 {
+	buffers.clocked[now.Tick] = now.HasClock
+
+	if now.HasClock {
+		return now.Clock
+	}
+
+	return float32(now.Tick)
+}
+
+/*
+	Converts the time Time gave an example recorded at tick to in-game time. Examples recorded before the clock was found are
+	timed by tick, and if the match never had a clock after all, those recorded with one are timed by their tick too.
+*/
+func (buffers *playerBuffers) DotaTime(raw float32, tick uint32, info *MatchInfo) float32 {
+	if clocked := buffers.clocked[tick]; clocked && info.HasClock {
+		return ClockTime(raw, info.StartClock)
+	} else if clocked {
+		return DotaTime(tick, info.StartTime)
+	}
+
+	return DotaTime(uint32(raw), info.StartTime)
+}
+
+func (buffers *playerBuffers) CreepFront(playerID int32, team uint64, fronts *LaneFronts, now Moment) float32 {
+	if _, ok := buffers.fronts[now.Tick]; !ok {
+		buffers.fronts[now.Tick] = fronts.Snapshot()
+	}
+
+	return 0
+}
+
+func (buffers *playerBuffers) Close() error {
+	var errs []error
+
+	for _, corpora := range buffers.players {
+		errs = append(errs, corpora.CloseCorpora(""))
+	}
+
+	return FirstError(errs...)
+}
+
+/*
+	Does the work of FirstPass and SecondPass in one go, for input that can't (or shouldn't) be read twice. Examples are recorded
+	for all ten players and only the selected players' are merged into corpora once the game is over. They're kept in memory,
+	spilling to temp files under scratch whenever budget runs out. Like FirstPass, matches without a winner return a *SkipError.
+*/
+func SinglePass(ctx context.Context, corpora *Corpora, demo io.Reader, selector PlayerSelector, scratch string, budget *MemoryBudget, options ExampleOptions) (*MatchInfo, error) {
+	parser, err := CreateParser(demo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buffers := newPlayerBuffers(scratch, budget)
+
+	defer func() {
+		for playerID := range buffers.files {
+			buffers.Release(playerID)
+		}
+	}()
+
+	info := WatchMatch(ctx, parser, selector)
+	flush := RecordExamples(ctx, parser, buffers, options)
+
+	err = parser.Start()
+
+	if err := FirstError(err, flush(), buffers.Close(), ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	if info.WinningTeam == 0 {
+		return nil, &SkipError{Reason: "match has no winner"}
+	}
+
+	corpora.Teams = append(corpora.Teams, info.Teams)
+
+	retime := func(example interface{}) {
+		switch example := example.(type) {
+		case *MoveExample:
+			example.DotaTime = buffers.DotaTime(example.DotaTime, example.Provenance.Tick, info)
+
+			if player := info.Top[example.Provenance.PlayerID]; player != nil {
+				example.CreepFront = buffers.fronts[example.Provenance.Tick][player.Team-2][info.Lanes[player.ID]]
+			}
+		case *BuildExample:
+			example.DotaTime = buffers.DotaTime(example.DotaTime, example.Provenance.Tick, info)
+		}
+	}
+
+	for playerID := range buffers.players {
+		if info.Top[playerID] == nil { // nobody needs their examples anymore
+			buffers.Release(playerID)
+		}
+	}
+
+	selected := make([]int, 0, len(info.Top))
+
+	for id := range info.Top {
+		selected = append(selected, int(id))
+	}
+
+	sort.Ints(selected)
+
+	for _, id := range selected {
+		if player, ok := buffers.players[int32(id)]; ok {
+			if _, err := corpora.MergeWith(player.Root, buffers.open(int32(id)), retime); err != nil {
+				return nil, err
+			}
+
+			buffers.Release(int32(id))
+		}
+	}
+
+	return info, nil
+}
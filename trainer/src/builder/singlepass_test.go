@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* Reads a spill file back. */
+func readSpillFile(t *testing.T, buffer *spillFile) string {
+	stream, err := buffer.Open()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stream.Close()
+
+	contents, err := ioutil.ReadAll(stream)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(contents)
+}
+
+func TestMemoryBudgetSpillsBiggestBuffers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buffers")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	budget := NewMemoryBudget(100)
+	big := newSpillFile(budget, filepath.Join(dir, "big"))
+	small := newSpillFile(budget, filepath.Join(dir, "small"))
+
+	big.Write(bytes.Repeat([]byte("a"), 70))
+	small.Write(bytes.Repeat([]byte("b"), 30))
+
+	if _, err := os.Stat(big.path); !os.IsNotExist(err) {
+		t.Errorf("spilled while the budget still held")
+	}
+
+	// 130 bytes now, spilling the 70 in the big buffer is enough
+	if _, err := small.Write(bytes.Repeat([]byte("b"), 30)); err != nil {
+		t.Fatal(err)
+	}
+
+	if budget.used != 60 || big.memory.Len() != 0 || small.memory.Len() != 60 {
+		t.Errorf("%d bytes in memory, %d of them in the big buffer", budget.used, big.memory.Len())
+	}
+
+	if _, err := os.Stat(small.path); !os.IsNotExist(err) {
+		t.Errorf("spilled more than the budget needed")
+	}
+
+	big.Write([]byte("c"))
+
+	if contents := readSpillFile(t, big); contents != strings.Repeat("a", 70)+"c" {
+		t.Errorf("read back %q", contents)
+	}
+
+	if contents := readSpillFile(t, small); contents != strings.Repeat("b", 60) {
+		t.Errorf("read back %q", contents)
+	}
+
+	budget.release(big)
+	budget.release(small)
+
+	if _, err := os.Stat(big.path); budget.used != 0 || !os.IsNotExist(err) {
+		t.Errorf("%d bytes still in use after release", budget.used)
+	}
+}
+
+func TestMemoryBudgetIsShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buffers")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Like several demos being parsed at once, each with their own buffers
+	budget := NewMemoryBudget(1000)
+	buffers := make([]*spillFile, 8)
+
+	var writers sync.WaitGroup
+
+	for i := range buffers {
+		buffers[i] = newSpillFile(budget, filepath.Join(dir, fmt.Sprint(i)))
+		writers.Add(1)
+
+		go func(buffer *spillFile, i int) {
+			defer writers.Done()
+
+			for j := 0; j < 100; j++ {
+				if _, err := fmt.Fprintf(buffer, "%d.%d,", i, j); err != nil {
+					t.Error(err)
+				}
+			}
+		}(buffers[i], i)
+	}
+
+	writers.Wait()
+
+	if budget.used > budget.limit {
+		t.Errorf("%d bytes in memory, over the %d byte budget", budget.used, budget.limit)
+	}
+
+	for i, buffer := range buffers {
+		expected := new(bytes.Buffer)
+
+		for j := 0; j < 100; j++ {
+			fmt.Fprintf(expected, "%d.%d,", i, j)
+		}
+
+		if contents := readSpillFile(t, buffer); contents != expected.String() {
+			t.Errorf("buffer %d read back %q", i, contents)
+		}
+	}
+}
+
+func TestPlayerBuffersTimeEachExampleByItsOwnClock(t *testing.T) {
+	buffers := newPlayerBuffers("", nil)
+
+	// The clock only shows up partway through, so earlier examples are timed by tick
+	early := buffers.Time(Moment{Tick: 100})
+	late := buffers.Time(Moment{Tick: 4000, Clock: 50, HasClock: true})
+
+	info := &MatchInfo{StartTime: 1000, StartClock: 20, HasClock: true}
+
+	if time := buffers.DotaTime(early, 100, info); time != DotaTime(100, 1000) {
+		t.Errorf("example timed by tick converted to %f", time)
+	}
+
+	if time := buffers.DotaTime(late, 4000, info); time != ClockTime(50, 20) {
+		t.Errorf("example timed by the clock converted to %f", time)
+	}
+
+	// Without a clock at the horn there's nothing to measure clock times from
+	info.HasClock = false
+
+	if time := buffers.DotaTime(late, 4000, info); time != DotaTime(4000, 1000) {
+		t.Errorf("example timed by the clock converted to %f without a clock at the horn", time)
+	}
+}
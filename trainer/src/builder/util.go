@@ -1,9 +1,8 @@
 package builder
 
 import (
-	"log"
-	"math"
-	"os"
+	"fmt"
+	"io"
 	"strings"
 
 	"github.com/dotabuff/manta"
@@ -19,16 +18,22 @@ const MAX_Y = 8288.0
 const CELL_SIZE = 128.0
 
 const COOLDOWN_SCALE = 360.0
+const CHARGES_SCALE = 20.0
 
 const TICKRATE = 30
 const ITEM_PERIOD = 1200
 
+/* Game states (DOTA_GameState) from the gamerules. */
+const GAME_IN_PROGRESS = 5
+const POST_GAME = 6
+
 /* Useful classnames. */
 const TOWER = "CDOTA_BaseNPC_Tower"
 const LANE_CREEP = "CDOTA_BaseNPC_Creep_Lane"
 const JUNGLE_CREEP = "CDOTA_BaseNPC_Creep_Neutral"
 const ANCIENT = "CDOTA_BaseNPC_Fort"
 const RUNE = "CDOTA_Item_Rune"
+const GAMERULES = "CDOTAGamerulesProxy"
 
 /* Utility functions. */
 func IsHero(ent *manta.Entity) bool {
@@ -45,6 +50,37 @@ func IsAbility(ent *manta.Entity) bool {
 	return classname == "CDOTABaseAbility" || strings.HasPrefix(classname, "CDOTA_Ability") && classname != "CDOTA_Ability_AttributeBonus"
 }
 
+/*
+	The slot type of an m_hItems slot: 6 main inventory slots, 3 backpack slots, 6 stash slots, the teleport scroll slot (which
+	is used like the main inventory) and the neutral item slot.
+*/
+func ItemSlotType(slot int) int {
+	switch {
+	case slot < 6:
+		return ItemSlotMain
+	case slot < 9:
+		return ItemSlotBackpack
+	case slot < 15:
+		return ItemSlotStash
+	case slot == 15:
+		return ItemSlotMain
+	default:
+		return ItemSlotNeutral
+	}
+}
+
+/*
+	Scaled seconds left on an item's cooldown. m_fCooldown is the game time the cooldown is over, so it's measured from the
+	game clock; 0 when the cooldown is over or there's no clock to measure from.
+*/
+func ItemCooldown(cooldownEnd float32, now Moment) float32 {
+	if !now.HasClock || cooldownEnd <= now.Clock {
+		return 0
+	}
+
+	return (cooldownEnd - now.Clock) / COOLDOWN_SCALE
+}
+
 /* Linearly maps coordinate components to [0, 1]. */
 func RemapX(x float32) float32 {
 	return (x+MIN_X)/(MAX_X-MIN_X) + 1
@@ -104,49 +140,69 @@ func GetHammerName(parser *manta.Parser, entity *manta.Entity) string {
 	return ""
 }
 
-/* Minimum index (for partial sorting players by kills in the first pass. No point in using a heap for just 3 elements) */
-func MinIndex(top map[int32]*TopPlayer) int32 {
-	best := int32(math.MaxInt32)
-	bestIndex := int32(0)
+/* Creates a Manta parser instance. */
+func CreateParser(demo io.Reader) (*manta.Parser, error) {
+	parser, err := manta.NewStreamParser(demo)
 
-	for i, player := range top {
-		if player.Kills < best {
-			best = player.Kills
-			bestIndex = i
-		}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create parser: %s", err)
 	}
 
-	return bestIndex
+	return parser, nil
 }
 
-/* Opens a demo file. */
-func OpenDemo(demo_name string) *os.File {
-	filehandle, err := os.Open(demo_name)
+/* Converts a handle to a regular entindex. */
+func Handle(i uint64) int32 {
+	return int32(i & (1<<14 - 1))
+}
 
-	if err != nil {
-		log.Fatal("Can't open demo")
-	}
+/* Converts ticks to in-game time (rough approximation, pauses count too). Only used for replays without a gamerules clock. */
+func DotaTime(tick uint32, startTime uint32) float32 {
+	return (float32(tick) - float32(startTime)) / (TICKRATE * 3600)
+}
 
-	return filehandle
+/* Converts the gamerules clock to in-game time, on the same scale as the DotaTime() / 3600 the bots send. */
+func ClockTime(clock float32, startClock float32) float32 {
+	return (clock - startClock) / 3600
 }
 
-/* Creates a Manta parser instance. */
-func CreateParser(demo *os.File) *manta.Parser {
-	parser, err := manta.NewStreamParser(demo)
+/*
+	The gamerules' clock in seconds, which stops during pauses. Older replays network it directly, newer ones only count the ticks
+	spent paused.
+*/
+func GameTime(parser *manta.Parser, gamerules *manta.Entity) (float32, bool) {
+	if now, ok := gamerules.GetFloat32("m_pGameRules.m_fGameTime"); ok {
+		return now, true
+	}
 
-	if err != nil {
-		log.Fatalf("Unable to create parser: %s\n", err)
+	if paused, ok := gamerules.GetInt32("m_pGameRules.m_nTotalPausedTicks"); ok {
+		return (float32(parser.Tick) - float32(paused)) / TICKRATE, true
 	}
 
-	return parser
+	return 0, false
 }
 
-/* Converts a handle to a regular entindex. */
-func Handle(i uint64) int32 {
-	return int32(i&(1<<14) - 1)
+/*
+	Works the tick of the horn out from the tick the game was first seen in progress, the gamerules clock then and the clock at
+	the horn, since the horn may have been a few updates before. The tick it was seen at if the clocks don't say any better.
+*/
+func HornTick(tick uint32, clock float32, started float32) uint32 {
+	if started > 0 && clock > started {
+		if elapsed := uint32((clock - started) * TICKRATE); elapsed < tick {
+			return tick - elapsed
+		}
+	}
+
+	return tick
 }
 
-/* Converts ticks to in-game time (rough approximation) */
-func DotaTime(tick uint32, startTime uint32) float32 {
-	return (float32(tick) - float32(startTime)) / (TICKRATE * 3600)
+/* Returns the first non-nil error, for cleanup paths that have to carry on after a failure. */
+func FirstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
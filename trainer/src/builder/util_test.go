@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	// Handles keep the entity's serial number above its 14 bit entindex
+	expected := map[uint64]int32{
+		421:                 421,
+		37<<14 | 421:        421,
+		1 << 14:             0,
+		1<<14 - 1:           1<<14 - 1,
+		0x1ffff<<14 | 16000: 16000,
+	}
+
+	for handle, entindex := range expected {
+		if Handle(handle) != entindex {
+			t.Errorf("handle %#x gives entindex %d, expected %d", handle, Handle(handle), entindex)
+		}
+	}
+}
+
+func TestItemCooldown(t *testing.T) {
+	now := Moment{Clock: 600, HasClock: true}
+
+	tests := []struct {
+		end      float32
+		now      Moment
+		expected float32
+	}{
+		{0, now, 0},                     // never used
+		{590, now, 0},                   // over
+		{636, now, 36 / COOLDOWN_SCALE}, // 36 seconds left
+		{636, Moment{Tick: 18000}, 0},   // no clock
+		{600 + COOLDOWN_SCALE, now, 1},  // as long as the longest cooldowns
+	}
+
+	for _, test := range tests {
+		if cooldown := ItemCooldown(test.end, test.now); cooldown != test.expected {
+			t.Errorf("cooldown ending at %g at %+v: %g, expected %g", test.end, test.now, cooldown, test.expected)
+		}
+	}
+}
+
+func TestItemSlotType(t *testing.T) {
+	expected := map[int]int{
+		0:  ItemSlotMain,
+		5:  ItemSlotMain,
+		6:  ItemSlotBackpack,
+		8:  ItemSlotBackpack,
+		9:  ItemSlotStash,
+		14: ItemSlotStash,
+		15: ItemSlotMain, // teleport scroll
+		16: ItemSlotNeutral,
+	}
+
+	for slot, slotType := range expected {
+		if ItemSlotType(slot) != slotType {
+			t.Errorf("slot %d has type %d, expected %d", slot, ItemSlotType(slot), slotType)
+		}
+	}
+}
+
+func TestHornTick(t *testing.T) {
+	expected := map[[3]float32]uint32{
+		{9000, 602, 600}: 9000 - 2*TICKRATE, // first seen in progress 2 seconds after the horn
+		{9000, 600, 600}: 9000,              // seen right at the horn
+		{9000, 590, 600}: 9000,              // clock behind the horn, nothing to work back from
+		{9000, 602, 0}:   9000,              // no horn clock
+		{30, 602, 600}:   30,                // would be before the replay started
+	}
+
+	for args, tick := range expected {
+		if horn := HornTick(uint32(args[0]), args[1], args[2]); horn != tick {
+			t.Errorf("HornTick(%v) = %d, expected %d", args, horn, tick)
+		}
+	}
+}
+
+func TestGameTimeConversions(t *testing.T) {
+	if time := DotaTime(3000+90*TICKRATE, 3000); time != 90.0/3600 {
+		t.Errorf("90 seconds of ticks after the horn is %f", time)
+	}
+
+	if time := DotaTime(3000-30*TICKRATE, 3000); time != -30.0/3600 {
+		t.Errorf("30 seconds of ticks before the horn is %f", time)
+	}
+
+	if time := ClockTime(190, 100); time != 90.0/3600 {
+		t.Errorf("90 seconds of clock after the horn is %f", time)
+	}
+}
@@ -0,0 +1,169 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+/* Checks that an item ID is in the vocabulary. */
+func checkItem(observed *Observed, id int) error {
+	if _, ok := LookupID(observed.ObservedItems, id, 1); !ok {
+		return fmt.Errorf("unknown item id %d", id)
+	}
+
+	return nil
+}
+
+/* Checks that an AbilityUsed/ItemUsed label is either "unknown", "none" or in the vocabulary. */
+func checkActive(dict map[string]int, id int) error {
+	if id <= 1 {
+		return nil
+	} else if _, ok := LookupID(dict, id, 2); !ok {
+		return fmt.Errorf("unknown ability/item id %d", id)
+	}
+
+	return nil
+}
+
+func (example *MoveExample) Validate(observed *Observed) error {
+	for _, item := range example.CurrentItems {
+		if err := checkItem(observed, item); err != nil {
+			return err
+		}
+	}
+
+	if n := len(example.CurrentItems); len(example.ItemCooldowns) != n || len(example.ItemCharges) != n || len(example.ItemSlots) != n {
+		return fmt.Errorf("%d items but %d item cooldowns, %d item charges and %d item slots", n, len(example.ItemCooldowns), len(example.ItemCharges), len(example.ItemSlots))
+	}
+
+	for _, slot := range example.ItemSlots {
+		if slot < ItemSlotMain || slot > ItemSlotNeutral {
+			return fmt.Errorf("invalid item slot type %d", slot)
+		}
+	}
+
+	if err := example.MoveOutputExample.Validate(observed); err != nil {
+		return err
+	}
+
+	// Queued orders are remapped like the first one, so their abilities and items have to be in the vocabulary too
+	for i, order := range example.Sequence {
+		if order.Queued != 1 {
+			return fmt.Errorf("unqueued order in a sequence")
+		} else if err := order.Validate(observed); err != nil {
+			return fmt.Errorf("queued order %d: %s", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (output *MoveOutputExample) Validate(observed *Observed) error {
+	if output.Target < 0 || output.Target > TargetFriendlyHero {
+		return fmt.Errorf("invalid target type %d", output.Target)
+	}
+
+	if _, ok := ACTION_CALLS[output.Action]; !ok {
+		return fmt.Errorf("invalid action %d", output.Action)
+	} else if output.Queued != 0 && output.Queued != 1 {
+		return fmt.Errorf("invalid queue flag %d", output.Queued)
+	}
+
+	if err := checkActive(observed.ObservedActiveAbilities, output.AbilityUsed); err != nil {
+		return err
+	}
+
+	return checkActive(observed.ObservedActiveItems, output.ItemUsed)
+}
+
+func (example *BuildExample) Validate(observed *Observed) error {
+	for item := range example.CurrentInventory {
+		if err := checkItem(observed, item); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range example.NewItems {
+		if err := checkItem(observed, item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+	Checks every corpus file under root: that it was closed properly, that every example decodes, that every ID in it is in
+	vocabulary.json and that move examples have as many extra features as features.json lays out. Returns one error per broken
+	file.
+*/
+func ValidateCorpora(root string) []error {
+	vocab, err := LoadVocabulary(root)
+
+	if err != nil {
+		return []error{err}
+	}
+
+	features, err := LoadFeatureSchema(root)
+
+	if err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+
+	dirs, err := ioutil.ReadDir(root)
+
+	if err != nil {
+		return []error{err}
+	}
+
+	for _, dir := range dirs {
+		if _, ok := vocab.Heroes[dir.Name()]; dir.IsDir() && dir.Name() != MATCHES_FOLDER && !ok {
+			problems = append(problems, fmt.Errorf("%s: hero is missing from %s", dir.Name(), VOCABULARY_FILE))
+		}
+	}
+
+	for hero, teams := range vocab.Heroes {
+		for i := range teams {
+			observed := &teams[i]
+
+			moves := CorpusPath(root, hero, i+2, "move")
+			err := ReadCorpus(moves, func(raw json.RawMessage) error {
+				example := &MoveExample{}
+
+				if err := json.Unmarshal(raw, example); err != nil {
+					return err
+				}
+
+				if len(example.Extra) != features.Size() {
+					return fmt.Errorf("%d extra features, %s lays out %d", len(example.Extra), FEATURES_FILE, features.Size())
+				}
+
+				return example.Validate(observed)
+			})
+
+			if err != nil {
+				problems = append(problems, err)
+			}
+
+			items := CorpusPath(root, hero, i+2, "items")
+			err = ReadCorpus(items, func(raw json.RawMessage) error {
+				example := &BuildExample{}
+
+				if err := json.Unmarshal(raw, example); err != nil {
+					return err
+				}
+
+				return example.Validate(observed)
+			})
+
+			if err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateSkipsMatches(t *testing.T) {
+	root := writeTestCorpora(t, func(corpus *Corpus) []*MoveExample { return nil })
+	defer os.RemoveAll(root)
+
+	metadata := &MatchMetadata{MatchID: 42, PicksBans: []PickBan{}, Selected: []*TopPlayer{}}
+
+	if err := metadata.Write(MatchPath(root, metadata.MatchID, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range ValidateCorpora(root) {
+		t.Error(err)
+	}
+}
+
+func TestValidateFindsUnknownHeroes(t *testing.T) {
+	root := writeTestCorpora(t, func(corpus *Corpus) []*MoveExample { return nil })
+	defer os.RemoveAll(root)
+
+	if err := os.Mkdir(filepath.Join(root, "npc_dota_hero_zuus"), 493); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := ValidateCorpora(root); len(problems) != 1 {
+		t.Errorf("expected the hero missing from %s to be reported, got %v", VOCABULARY_FILE, problems)
+	}
+}
@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"github.com/dotabuff/manta"
+)
+
+/* How long (in ticks) the bots keep using where a hero was last seen before falling back to its team's home shop. */
+const LAST_SEEN_TIMEOUT = 5 * TICKRATE
+
+/* Where GetShopLocation(team, SHOP_HOME) is, in world coordinates, by team. */
+var HOME_SHOPS = map[uint64][2]float32{
+	2: {-7150, -6650},
+	3: {7050, 6450},
+}
+
+/* What one team knows about where a hero is. */
+type sighting struct {
+	visible bool
+	x       float32 // remapped, like GetLocation
+	y       float32
+	tick    uint32 // when it was last seen
+}
+
+/*
+	Tracks each team's vision of the heroes, so the positions in examples are only what the bot playing the hero could know:
+	heroes in vision where they are, heroes that left vision less than 5 seconds ago where they were last seen (like
+	GetHeroLastSeenInfo) and everyone else at their team's home shop, like StartMoveThink falls back to.
+*/
+type Vision struct {
+	sightings map[int32]*[2]sighting // by hero entindex and observing team (Radiant, Dire)
+}
+
+func NewVision() *Vision {
+	return &Vision{make(map[int32]*[2]sighting)}
+}
+
+/* Whether a team can see an entity right now. Dead heroes can't be seen. */
+func IsVisible(ent *manta.Entity, team uint64) bool {
+	if lifeState, _ := ent.GetInt32("m_lifeState"); lifeState != 0 {
+		return false
+	}
+
+	mask, _ := ent.GetUint64("m_iTaggedAsVisibleByTeam")
+	return mask&(1<<team) != 0
+}
+
+/* Keeps track of who sees a hero, on every update of it. */
+func (vision *Vision) Update(ent *manta.Entity, tick uint32) {
+	vision.Observe(ent.GetIndex(), GetLocation(ent), [2]bool{IsVisible(ent, 2), IsVisible(ent, 3)}, tick)
+}
+
+/* Update, given where the hero with the given entindex is (remapped) and whether Radiant and Dire see it. */
+func (vision *Vision) Observe(entindex int32, coords []float32, visible [2]bool, tick uint32) {
+	seen, ok := vision.sightings[entindex]
+
+	if !ok {
+		seen = &[2]sighting{}
+		vision.sightings[entindex] = seen
+	}
+
+	for team := uint64(2); team <= 3; team++ {
+		if visible[team-2] {
+			seen[team-2] = sighting{true, coords[0], coords[1], tick}
+		} else if seen[team-2].visible { // just lost sight of it
+			seen[team-2].visible = false
+			seen[team-2].tick = tick
+		}
+	}
+}
+
+/* Where observer thinks the hero with the given entindex and team is at tick, in remapped coordinates. */
+func (vision *Vision) Location(parser *manta.Parser, entindex int32, team uint64, observer uint64, tick uint32) []float32 {
+	location, visible := vision.Known(entindex, team, observer, tick)
+
+	if visible {
+		if ent := parser.FindEntity(entindex); ent != nil {
+			return GetLocation(ent)
+		}
+	}
+
+	return location
+}
+
+/* Where observer last knew the hero to be at tick, and whether it's in vision so where it is right now can be used instead. */
+func (vision *Vision) Known(entindex int32, team uint64, observer uint64, tick uint32) ([]float32, bool) {
+	if seen, ok := vision.sightings[entindex]; ok && observer >= 2 && observer <= 3 {
+		if sighting := seen[observer-2]; sighting.visible {
+			return []float32{sighting.x, sighting.y}, true
+		} else if sighting.tick != 0 && tick-sighting.tick <= LAST_SEEN_TIMEOUT {
+			return []float32{sighting.x, sighting.y}, false
+		}
+	}
+
+	shop := HOME_SHOPS[team]
+	return []float32{RemapX(shop[0]), RemapY(shop[1])}, false
+}
@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestVisionFallsBackToHomeShop(t *testing.T) {
+	vision := NewVision()
+	dire := fmt.Sprint([]float32{RemapX(HOME_SHOPS[3][0]), RemapY(HOME_SHOPS[3][1])})
+
+	// A Dire hero Radiant has never seen is at its home shop, as far as they know
+	if location, visible := vision.Known(7, 3, 2, 100); visible || fmt.Sprint(location) != dire {
+		t.Errorf("unseen hero at %v (visible %t)", location, visible)
+	}
+
+	vision.Observe(7, []float32{0.25, 0.5}, [2]bool{false, true}, 100)
+
+	if location, visible := vision.Known(7, 3, 2, 200); visible || fmt.Sprint(location) != dire {
+		t.Errorf("hero only Dire sees is at %v for Radiant (visible %t)", location, visible)
+	}
+
+	if location, visible := vision.Known(7, 3, 3, 200); !visible || fmt.Sprint(location) != "[0.25 0.5]" {
+		t.Errorf("hero is at %v for its own team (visible %t)", location, visible)
+	}
+
+	// Radiant sees it walk a bit, then loses it
+	vision.Observe(7, []float32{0.25, 0.5}, [2]bool{true, true}, 300)
+	vision.Observe(7, []float32{0.3, 0.5}, [2]bool{true, true}, 310)
+	vision.Observe(7, []float32{0.4, 0.6}, [2]bool{false, true}, 320)
+
+	if location, visible := vision.Known(7, 3, 2, 320+LAST_SEEN_TIMEOUT); visible || fmt.Sprint(location) != "[0.3 0.5]" {
+		t.Errorf("hero that left vision is at %v (visible %t), expected where it was last seen", location, visible)
+	}
+
+	if location, _ := vision.Known(7, 3, 2, 321+LAST_SEEN_TIMEOUT); fmt.Sprint(location) != dire {
+		t.Errorf("hero out of vision for over %d ticks is at %v, expected its home shop", LAST_SEEN_TIMEOUT, location)
+	}
+
+	// Staying out of vision doesn't restart the timeout
+	vision.Observe(7, []float32{0.5, 0.6}, [2]bool{false, true}, 330)
+
+	if location, _ := vision.Known(7, 3, 2, 321+LAST_SEEN_TIMEOUT); fmt.Sprint(location) != dire {
+		t.Errorf("hero out of vision for over %d ticks is at %v after another update", LAST_SEEN_TIMEOUT, location)
+	}
+}
@@ -1,7 +1,15 @@
-require "ability_data"
 require "nn"
 require "json"
 
+-- Corpora folder to train on; a patch folder such as data/7.33 if the corpus builder split the corpora by patch
+local DATA = arg[1] or "data"
+
+if paths.filep(DATA .. "/ability_data.lua") then
+	dofile(DATA .. "/ability_data.lua") -- the patch's own vocabulary
+else
+	require "ability_data"
+end
+
 -- Hyper parameters
 local MINI_BATCH_SIZE = 100 -- number of examples in a batch
 local PATIENCE = 15 -- how long we should put up with the validation error increasing before stopping
@@ -198,7 +206,7 @@ local function ParseMoveBatch(examples, hero, team, totals)
 end
 
 local function LoadData(hero, team)
-	local path = string.format("data/%s/%d_", hero, team)
+	local path = string.format("%s/%s/%d_", DATA, hero, team)
 
 	local move_file = io.open(path .. "moveexamples", "r")
 	local move_data = json.decode(move_file:read("*all"))
@@ -251,9 +259,13 @@ local function LoadData(hero, team)
 	return move_data, items_data, move_label_weights, move_class_weights
 end
 
-for hero in paths.iterdirs("data") do
+for hero in paths.iterdirs(DATA) do
+	if not paths.filep(DATA .. "/" .. hero .. "/2_moveexamples") then -- matches, patch folders...
+		goto continue
+	end
+
 	print("Training " .. hero)
-	paths.mkdir("data/" .. hero .. "/nets")
+	paths.mkdir(DATA .. "/" .. hero .. "/nets")
 
 	do
 		print("\nRadiant")
@@ -275,7 +287,7 @@ for hero in paths.iterdirs("data") do
 			Train(move, move_data, Loss(move_label_weights, move_class_weights), 
 					{MOVE_INFO_LEN, NUM_TARGET, num_abilities, num_items})
 
-			torch.save("data/" .. hero .. "/nets/2_move", move, "ascii")
+			torch.save(DATA .. "/" .. hero .. "/nets/2_move", move, "ascii")
 
 			--print("Items/build:")
 			--Train(items, items_data, .1, nn.ClassNLLCriterion(), items_size)
@@ -303,11 +315,13 @@ for hero in paths.iterdirs("data") do
 			Train(move, move_data, Loss(move_label_weights, move_class_weights),
 					{MOVE_INFO_LEN, NUM_TARGET, num_abilities, num_items})
 
-			torch.save("data/" .. hero .. "/nets/3_move", move, "ascii")
+			torch.save(DATA .. "/" .. hero .. "/nets/3_move", move, "ascii")
 
 			--print("Items/build:")
 			--Train(items, items_data, .1, nn.ClassNLLCriterion(), items_size)
 			--torch.save(move, "../data" .. hero .. "3_itemsnn")
 		end
 	end
+
+	::continue::
 end